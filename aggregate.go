@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Iteration holds the measures of one iteration of a trial, i.e. one row of r1.txt
+type Iteration struct {
+	Subject    int
+	Block      string
+	Trial      string
+	Index      int
+	Levels     *IVLevels
+	Addition   float64
+	Target     float64
+	Complete   float64
+	Hits       int
+	FriendHits int
+	Shots      int
+	Hovers     int
+	Op1        int
+	Op2        int
+}
+
+// the variables of an iteration that can be used in filters, with their types and whether
+// they are known from the block before its trials are parsed
+var iterationVariables = map[string]struct {
+	kind  exprKind
+	block bool
+}{
+	"subject": {numberKind, true}, "block": {stringKind, true}, "trial": {stringKind, false}, "iteration": {numberKind, false},
+	"practice": {boolKind, true}, "targets": {numberKind, true}, "speed": {numberKind, true},
+	"oprange": {stringKind, true}, "difficulty": {numberKind, true},
+	"addition": {numberKind, false}, "target": {numberKind, false}, "complete": {numberKind, false},
+	"hits": {numberKind, false}, "friendHits": {numberKind, false}, "shots": {numberKind, false},
+	"hovers": {numberKind, false}, "op1": {numberKind, false}, "op2": {numberKind, false},
+}
+
+// get the name of the operand range level of a block: "low", "high", "none" for blocks without addition,
+// or the range as printed in r1.txt if it is not one of the experiment's levels
+func opRangeLevel(levels *IVLevels) string {
+	switch fmt.Sprint(levels.AdditionDifficulty) {
+	case "[]":
+		return "none"
+	case "[1 12]":
+		return "low"
+	case "[13 25]":
+		return "high"
+	}
+	return fmt.Sprint(levels.AdditionDifficulty)
+}
+
+// get a variable of an iteration by name
+func (it Iteration) field(name string) interface{} {
+	switch name {
+	case "subject":
+		return float64(it.Subject)
+	case "block":
+		return it.Block
+	case "trial":
+		return it.Trial
+	case "iteration":
+		return float64(it.Index)
+	case "practice":
+		return it.Levels.Practice
+	case "targets":
+		return float64(it.Levels.TargetNumber)
+	case "speed":
+		return float64(it.Levels.TargetSpeed)
+	case "oprange":
+		return opRangeLevel(it.Levels)
+	case "difficulty":
+		return float64(it.Levels.TargetDifficulty)
+	case "addition":
+		return it.Addition
+	case "target":
+		return it.Target
+	case "complete":
+		return it.Complete
+	case "hits":
+		return float64(it.Hits)
+	case "friendHits":
+		return float64(it.FriendHits)
+	case "shots":
+		return float64(it.Shots)
+	case "hovers":
+		return float64(it.Hovers)
+	case "op1":
+		return float64(it.Op1)
+	case "op2":
+		return float64(it.Op2)
+	}
+	return nil
+}
+
+// build the iterations of a trial from the parsed measures
+func makeIterations(subject int, block, trial string, levels *IVLevels, times map[string][]float64) []Iteration {
+	iterations := make([]Iteration, len(times["complete"]))
+	for index := range iterations {
+		iterations[index] = Iteration{
+			Subject: subject, Block: block, Trial: trial, Index: index, Levels: levels,
+			Addition: times["addition"][index], Target: times["finalHit"][index], Complete: times["complete"][index],
+			Hits: int(times["hits"][index]), FriendHits: int(times["friendHits"][index]), Shots: int(times["shots"][index]),
+			Hovers: int(times["friendHovers"][index]), Op1: int(times["op1"][index]), Op2: int(times["op2"][index]),
+		}
+	}
+	return iterations
+}
+
+// print an iteration as a row of r1.txt
+func (it Iteration) printR(w io.Writer) {
+	fmt.Fprintf(w, "%t, %d, %d, %v, %d, %f, %f, %f, %d, %d, %d, %d, %d, %d\n",
+		it.Levels.Practice,
+		it.Levels.TargetNumber, it.Levels.TargetSpeed, it.Levels.AdditionDifficulty, it.Levels.TargetDifficulty,
+		it.Addition, it.Target, it.Complete, it.Hits,
+		it.FriendHits, it.Shots, it.Hovers, it.Op1, it.Op2)
+}
+
+// print the header of the combined file of all subjects
+func printCombinedHeader(w io.Writer) {
+	fmt.Fprint(w, "subject, block, trial, iteration, ")
+	printRHeader(w)
+}
+
+// print an iteration as a row of the combined file of all subjects
+func (it Iteration) printCombined(w io.Writer) {
+	fmt.Fprintf(w, "%d, %s, %s, %d, ", it.Subject, it.Block, it.Trial, it.Index)
+	it.printR(w)
+}
+
+// settings that select and check the trials to aggregate
+type aggregateOptions struct {
+	// only aggregate this block if set
+	blockName string
+	// only aggregate this trial number if not -1
+	trialNum int
+	// the number of iterations expected in each trial
+	iterations int
+	// the number of trials to process at once
+	workers int
+	// the cache of parsed trials, or nil to parse every trial
+	cache *resultCache
+	// the blocks and iterations to aggregate, or nil for all of them
+	where *filter
+	// the rule flagging outlier iterations, or nil to not flag them
+	outliers *outlierRule
+}
+
+// a trial to be aggregated
+type trialJob struct {
+	subject int
+	block   string
+	trial   string
+	levels  *IVLevels
+}
+
+// the iterations of an aggregated trial, or the reason it could not be aggregated
+type trialResult struct {
+	iterations []Iteration
+	// set if the iterations came from the cache
+	cached bool
+	err    error
+}
+
+// list the trials of a subject to aggregate, in block and trial order
+func subjectJobs(layout *Layout, subject int, options aggregateOptions) []trialJob {
+	var blocks []string
+	if options.blockName == "" {
+		blocks = layout.Blocks(subject)
+	} else {
+		blocks = []string{options.blockName}
+	}
+
+	jobs := make([]trialJob, 0)
+	for _, block := range blocks {
+		// get block IV levels
+		levels := getBlockIVLevels(layout, subject, block)
+		// TODO get this to work with practice blocks
+		if levels == nil {
+			continue
+		}
+		if options.where != nil && !options.where.matchBlock(subject, block, levels) {
+			continue
+		}
+
+		var trials []string
+		if options.trialNum == -1 {
+			trials = layout.Trials(subject, block)
+		} else {
+			trials = []string{layout.TrialName(options.trialNum)}
+		}
+		for _, trial := range trials {
+			jobs = append(jobs, trialJob{subject, block, trial, levels})
+		}
+	}
+	return jobs
+}
+
+// aggregate a single trial
+func aggregateTrial(layout *Layout, job trialJob, options aggregateOptions) trialResult {
+	var times map[string][]float64
+	var cached bool
+	var err error
+	// TODO magic number 12 iterations should be looked up
+	if options.cache != nil {
+		times, cached, err = options.cache.trialTimes(layout, job, options.iterations)
+	} else {
+		var lines []string
+		if lines, err = readTrialLines(layout, job.subject, job.block, job.trial); err == nil {
+			times, err = trialTimes(lines, job.levels, options.iterations)
+		}
+	}
+	if err != nil {
+		return trialResult{err: fmt.Errorf("%s: %v", layout.TrialDir(job.subject, job.block, job.trial), err)}
+	}
+	iterations := makeIterations(job.subject, job.block, job.trial, job.levels, times)
+	if options.where != nil {
+		kept := make([]Iteration, 0, len(iterations))
+		for _, it := range iterations {
+			if options.where.matchIteration(it) {
+				kept = append(kept, it)
+			}
+		}
+		iterations = kept
+	}
+	return trialResult{iterations, cached, nil}
+}
+
+// aggregate trials with a bounded number of workers. results are in the same order as the jobs
+func runJobs(layout *Layout, jobs []trialJob, options aggregateOptions) []trialResult {
+	results := make([]trialResult, len(jobs))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	workers := options.workers
+	if workers < 1 {
+		workers = 1
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				results[index] = aggregateTrial(layout, jobs[index], options)
+			}
+		}()
+	}
+	for index := range jobs {
+		indices <- index
+	}
+	close(indices)
+	wg.Wait()
+	return results
+}
+
+// write a result file of iterations. if outliers is set, each row ends with whether the iteration is an outlier and the rule
+func writeIterations(layout *Layout, path string, iterations []Iteration, combined bool, outliers []bool, rule *outlierRule) error {
+	var file io.WriteCloser = nopWriteCloser{os.Stdout}
+	if path != "-" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		file = f
+	}
+	file = layout.joinSubjects(file)
+	var line bytes.Buffer
+	// end a line with the outlier columns if there are any
+	endLine := func(extra string) {
+		if outliers != nil {
+			line.Truncate(line.Len() - 1)
+			line.WriteString(extra + "\n")
+		}
+		file.Write(line.Bytes())
+		line.Reset()
+	}
+	// a subject's file starts with the subject too, so the registry's columns are joined onto it
+	if combined {
+		printCombinedHeader(&line)
+	} else {
+		fmt.Fprint(&line, "subject, ")
+		printRHeader(&line)
+	}
+	endLine(", outlier, rule")
+	for index, it := range iterations {
+		if combined {
+			it.printCombined(&line)
+		} else {
+			fmt.Fprintf(&line, "%d, ", it.Subject)
+			it.printR(&line)
+		}
+		if outliers != nil {
+			endLine(fmt.Sprintf(", %t, %s", outliers[index], rule))
+		} else {
+			endLine("")
+		}
+	}
+	return file.Close()
+}
+
+// get the path of the combined file of all subjects given the --out setting
+func combinedPath(out string, layout *Layout) string {
+	if out == "" {
+		return filepath.Join(layout.Root, "all.txt")
+	}
+	if isDirSetting(out) {
+		return filepath.Join(out, "all.txt")
+	}
+	return out
+}
+
+// aggregate the trials of the subjects and write the per-subject result files. if combined is set
+// a file of all subjects is written too, and a file or stdout --out setting receives only that file.
+// returns false if any trial or file failed
+func aggregate(layout *Layout, subjects []int, combined bool, out string, options aggregateOptions) bool {
+	// collect the jobs of all subjects so the workers are kept busy across subjects
+	jobs := make([]trialJob, 0)
+	for _, subject := range subjects {
+		jobs = append(jobs, subjectJobs(layout, subject, options)...)
+	}
+	results := runJobs(layout, jobs, options)
+	if options.cache != nil {
+		cached := 0
+		for _, result := range results {
+			if result.cached {
+				cached++
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%d trials, %d unchanged since they were cached\n", len(results), cached)
+	}
+
+	ok := true
+	all := make([]Iteration, 0)
+	var allOutliers []bool
+	if options.outliers != nil {
+		allOutliers = make([]bool, 0)
+	}
+	next := 0
+	for _, subject := range subjects {
+		// jobs are in subject order, so each subject's results are contiguous
+		subjectOK := true
+		iterations := make([]Iteration, 0)
+		for ; next < len(jobs) && jobs[next].subject == subject; next++ {
+			if results[next].err != nil {
+				fmt.Fprintln(os.Stderr, results[next].err)
+				subjectOK = false
+			}
+			iterations = append(iterations, results[next].iterations...)
+		}
+		if !subjectOK {
+			fmt.Fprintf(os.Stderr, "not writing results of subject %d\n", subject)
+			ok = false
+			continue
+		}
+		var flags []bool
+		if options.outliers != nil {
+			flags = flagOutliers(makeObservations(layout, iterations), options.outliers)
+			fmt.Fprintf(os.Stderr, "subject %d: %d of %d iterations are outliers by %s\n", subject, countFlags(flags), len(flags), options.outliers)
+			allOutliers = append(allOutliers, flags...)
+		}
+		all = append(all, iterations...)
+		if !combined || out == "" || isDirSetting(out) {
+			if err := writeIterations(layout, outputPath(out, layout, subject, "r1.txt"), iterations, false, flags, options.outliers); err != nil {
+				fmt.Fprintf(os.Stderr, "error writing results of subject %d: %v\n", subject, err)
+				ok = false
+			}
+		}
+	}
+
+	if combined {
+		if err := writeIterations(layout, combinedPath(out, layout), all, true, allOutliers, options.outliers); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing combined results: %v\n", err)
+			ok = false
+		}
+	}
+	return ok
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// the cell means of a measure for each subject in a within-subject design
+type cellMeans struct {
+	factors []string
+	// the levels of each factor in order
+	levels [][]string
+	// the subjects with a mean in every cell
+	subjects []int
+	// the means of each subject, with cells ordered so the last factor varies fastest
+	means [][]float64
+	// subjects left out for missing cells
+	incomplete []int
+}
+
+// average a measure for each subject in each cell of the factors. subjects missing a cell are left out
+func makeCellMeans(observations []Observation, measure string, factors []string) *cellMeans {
+	cells := &cellMeans{factors: factors, levels: make([][]string, len(factors))}
+	for f, factor := range factors {
+		seen := make(map[string]bool)
+		for _, o := range observations {
+			if level := o.level(factor); !seen[level] {
+				seen[level] = true
+				cells.levels[f] = append(cells.levels[f], level)
+			}
+		}
+		sort.Slice(cells.levels[f], func(i, j int) bool { return levelLess(cells.levels[f][i], cells.levels[f][j]) })
+	}
+
+	cellIndex := func(o Observation) int {
+		index := 0
+		for f, factor := range factors {
+			level := o.level(factor)
+			position := 0
+			for position < len(cells.levels[f]) && cells.levels[f][position] != level {
+				position++
+			}
+			index = index*len(cells.levels[f]) + position
+		}
+		return index
+	}
+	count := 1
+	for _, levels := range cells.levels {
+		count *= len(levels)
+	}
+
+	bySubject := make(map[int][][]float64)
+	for _, o := range observations {
+		if _, ok := bySubject[o.Subject]; !ok {
+			bySubject[o.Subject] = make([][]float64, count)
+		}
+		bySubject[o.Subject][cellIndex(o)] = append(bySubject[o.Subject][cellIndex(o)], o.measure(measure))
+	}
+	subjects := make([]int, 0, len(bySubject))
+	for subject := range bySubject {
+		subjects = append(subjects, subject)
+	}
+	sort.Ints(subjects)
+	for _, subject := range subjects {
+		means := make([]float64, count)
+		complete := true
+		for cell, values := range bySubject[subject] {
+			var n int
+			means[cell], n = mean(values)
+			complete = complete && n > 0
+		}
+		if complete {
+			cells.subjects = append(cells.subjects, subject)
+			cells.means = append(cells.means, means)
+		} else {
+			cells.incomplete = append(cells.incomplete, subject)
+		}
+	}
+	return cells
+}
+
+// keep the non-practice observations of a type of task, or of every type for "all", that are not flagged
+func selectTask(observations []Observation, taskFilter string, flagged []bool) []Observation {
+	kept := make([]Observation, 0, len(observations))
+	for index, o := range observations {
+		if o.Levels.Practice || taskFilter != "all" && o.Type != taskFilter || flagged != nil && flagged[index] {
+			continue
+		}
+		kept = append(kept, o)
+	}
+	return kept
+}
+
+// the orthonormal Helmert contrasts of a factor with k levels, as k rows of k-1 columns
+func helmertContrasts(k int) [][]float64 {
+	contrasts := make([][]float64, k)
+	for level := range contrasts {
+		contrasts[level] = make([]float64, k-1)
+	}
+	for column := 1; column < k; column++ {
+		norm := math.Sqrt(float64(column + column*column))
+		for level := 0; level < column; level++ {
+			contrasts[level][column-1] = 1 / norm
+		}
+		contrasts[column][column-1] = -float64(column) / norm
+	}
+	return contrasts
+}
+
+// the Kronecker product of two matrices
+func kronecker(a, b [][]float64) [][]float64 {
+	product := make([][]float64, len(a)*len(b))
+	for i := range a {
+		for k := range b {
+			row := make([]float64, 0, len(a[i])*len(b[k]))
+			for _, x := range a[i] {
+				for _, y := range b[k] {
+					row = append(row, x*y)
+				}
+			}
+			product[i*len(b)+k] = row
+		}
+	}
+	return product
+}
+
+// a row of the ANOVA table
+type anovaEffect struct {
+	Name       string
+	DFn, DFd   float64
+	SS, SSE, F float64
+	P, Eta2p   float64
+	// the Greenhouse-Geisser epsilon and corrected p, NaN for effects with one degree of freedom
+	Epsilon, PGG float64
+}
+
+// run a repeated-measures ANOVA over the cell means. each effect is tested on the subjects' scores on orthonormal
+// contrasts for the effect's factors, averaged over the other factors, which gives the univariate F test and
+// the covariance of the contrasts for the Greenhouse-Geisser epsilon
+func repeatedMeasuresANOVA(cells *cellMeans) []anovaEffect {
+	n := len(cells.subjects)
+	effects := make([]anovaEffect, 0)
+	// every non-empty subset of the factors, main effects first
+	subsets := make([][]int, 0)
+	for mask := 1; mask < 1<<uint(len(cells.factors)); mask++ {
+		subset := make([]int, 0)
+		for f := range cells.factors {
+			if mask&(1<<uint(f)) != 0 {
+				subset = append(subset, f)
+			}
+		}
+		subsets = append(subsets, subset)
+	}
+	sort.SliceStable(subsets, func(i, j int) bool { return len(subsets[i]) < len(subsets[j]) })
+
+	for _, subset := range subsets {
+		in := make(map[int]bool)
+		names := make([]string, 0)
+		for _, f := range subset {
+			in[f] = true
+			names = append(names, cells.factors[f])
+		}
+		contrasts := [][]float64{{1}}
+		for f, levels := range cells.levels {
+			k := len(levels)
+			var factorMatrix [][]float64
+			if in[f] {
+				factorMatrix = helmertContrasts(k)
+			} else {
+				factorMatrix = make([][]float64, k)
+				for level := range factorMatrix {
+					factorMatrix[level] = []float64{1 / math.Sqrt(float64(k))}
+				}
+			}
+			contrasts = kronecker(contrasts, factorMatrix)
+		}
+		p := len(contrasts[0])
+
+		// the scores of each subject on the contrasts
+		scores := make([][]float64, n)
+		means := make([]float64, p)
+		for s := range scores {
+			scores[s] = make([]float64, p)
+			for c := 0; c < p; c++ {
+				for cell, value := range cells.means[s] {
+					scores[s][c] += value * contrasts[cell][c]
+				}
+				means[c] += scores[s][c] / float64(n)
+			}
+		}
+		// the sums of squares and products of the deviations of the scores
+		sscp := make([][]float64, p)
+		for i := range sscp {
+			sscp[i] = make([]float64, p)
+			for j := range sscp[i] {
+				for s := range scores {
+					sscp[i][j] += (scores[s][i] - means[i]) * (scores[s][j] - means[j])
+				}
+			}
+		}
+		effect := anovaEffect{Name: strings.Join(names, ":"), DFn: float64(p), DFd: float64(p * (n - 1)),
+			Epsilon: math.NaN(), PGG: math.NaN()}
+		for c := 0; c < p; c++ {
+			effect.SS += float64(n) * means[c] * means[c]
+			effect.SSE += sscp[c][c]
+		}
+		effect.F = (effect.SS / effect.DFn) / (effect.SSE / effect.DFd)
+		effect.P = fTest(effect.F, effect.DFn, effect.DFd)
+		effect.Eta2p = effect.SS / (effect.SS + effect.SSE)
+		if p > 1 {
+			trace, traceSquared := 0.0, 0.0
+			for i := range sscp {
+				trace += sscp[i][i]
+				for j := range sscp {
+					traceSquared += sscp[i][j] * sscp[j][i]
+				}
+			}
+			effect.Epsilon = trace * trace / (float64(p) * traceSquared)
+			effect.PGG = fTest(effect.F, effect.Epsilon*effect.DFn, effect.Epsilon*effect.DFd)
+		}
+		effects = append(effects, effect)
+	}
+	return effects
+}
+
+// write an ANOVA table
+func writeANOVA(w io.Writer, effects []anovaEffect) {
+	fmt.Fprintln(w, "effect, dfn, dfd, F, p, eta2p, epsilon, pGG")
+	for _, e := range effects {
+		fmt.Fprintf(w, "%s, %g, %g, %s, %s, %s, %s, %s\n", e.Name, e.DFn, e.DFd,
+			formatStat(e.F), formatStat(e.P), formatStat(e.Eta2p), formatStat(e.Epsilon), formatStat(e.PGG))
+	}
+}
+
+func init() {
+	addCommand(&command{
+		name:  "anova",
+		short: "run a repeated-measures ANOVA of a measure over the conditions",
+		long: `Anova averages a measure for each subject in each cell of the -factors, by default target
+speed, targeting difficulty and operand range of the dual task blocks, and runs a repeated-measures
+ANOVA with every main effect and interaction. For each effect it writes the degrees of freedom,
+F, p, partial eta squared and, for effects with more than one degree of freedom, the
+Greenhouse-Geisser epsilon and corrected p. Subjects without data in every cell are left out.
+
+Practice blocks are always left out and -type chooses the type of task (addition, targeting
+or main, or all for every type). -where, -source and -outliers select iterations as for summary.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			selection := addAnalysisSelectionFlags(flags)
+			caching := addCacheFlags(flags)
+			outliers := addOutlierFlags(flags)
+			var factorList, measure, taskFilter, where, source, out string
+			flags.StringVar(&factorList, "factors", "speed,difficulty,oprange", "The within-subject factors, separated by commas")
+			flags.StringVar(&measure, "measure", "complete", "The measure to analyse")
+			flags.StringVar(&taskFilter, "type", "main", "The type of task to analyse: addition, targeting, main or all")
+			flags.StringVar(&where, "where", "", "Only use the iterations for which an expression is true")
+			flags.StringVar(&source, "source", "", "Only use trials from this source, human or model")
+			flags.StringVar(&out, "out", "-", "The file to write, or - for stdout")
+			return func(args []string) error {
+				factors, err := parseNameList(factorList, isFactor, "factor")
+				if err != nil {
+					return err
+				}
+				if len(factors) == 0 {
+					return fmt.Errorf("no factors given")
+				}
+				if contains(factors, "subject") {
+					return fmt.Errorf("subject cannot be a within-subject factor")
+				}
+				if !isMeasure(measure) {
+					return fmt.Errorf("unknown measure %q", measure)
+				}
+				rule, err := outliers.rule()
+				if err != nil {
+					return err
+				}
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				if where != "" {
+					if selection.options.where, err = parseFilter(where); err != nil {
+						return err
+					}
+				}
+				if selection.options.cache, err = caching.cache(layout); err != nil {
+					return err
+				}
+				observations, err := loadObservations(layout, selection.subjects(layout), source, selection.options)
+				if err != nil {
+					return err
+				}
+				var flagged []bool
+				if rule != nil {
+					flagged = flagOutliers(observations, rule)
+					fmt.Fprintf(os.Stderr, "leaving out %d of %d iterations that are outliers by %s\n", countFlags(flagged), len(flagged), rule)
+				}
+				cells := makeCellMeans(selectTask(observations, taskFilter, flagged), measure, factors)
+				if len(cells.incomplete) > 0 {
+					fmt.Fprintf(os.Stderr, "leaving out subjects without data in every cell: %v\n", cells.incomplete)
+				}
+				if len(cells.subjects) < 2 {
+					return fmt.Errorf("need at least 2 subjects with data in every cell, have %d", len(cells.subjects))
+				}
+				for f, factor := range factors {
+					if len(cells.levels[f]) < 2 {
+						return fmt.Errorf("factor %s has only one level", factor)
+					}
+				}
+
+				w, err := layout.openOutput(out)
+				if err != nil {
+					return err
+				}
+				writeANOVA(w, repeatedMeasuresANOVA(cells))
+				return w.Close()
+			}
+		},
+	})
+}
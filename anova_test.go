@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHelmertContrastsOrthonormal(t *testing.T) {
+	for k := 2; k <= 5; k++ {
+		contrasts := helmertContrasts(k)
+		for i := 0; i < k-1; i++ {
+			sum := 0.0
+			for level := range contrasts {
+				sum += contrasts[level][i]
+			}
+			checkClose(t, "the sum of a contrast", sum, 0, 1e-12)
+			for j := 0; j < k-1; j++ {
+				product := 0.0
+				for level := range contrasts {
+					product += contrasts[level][i] * contrasts[level][j]
+				}
+				want := 0.0
+				if i == j {
+					want = 1
+				}
+				checkClose(t, "the product of two contrasts", product, want, 1e-12)
+			}
+		}
+	}
+}
+
+// a 2 x 3 within-subject design of 6 subjects. the expected values are from the textbook sums of squares of the
+// subject x effect error terms and Box's epsilon of the covariance of the subjects' means, which is what ez's
+// ezANOVA and afex's aov_car report, with p values from the F distribution
+func TestRepeatedMeasuresANOVA(t *testing.T) {
+	data := [][]float64{
+		{12, 15, 19, 10, 14, 14},
+		{9, 13, 20, 11, 12, 15},
+		{14, 14, 22, 12, 17, 18},
+		{8, 12, 15, 9, 10, 16},
+		{11, 17, 21, 10, 13, 13},
+		{13, 12, 18, 12, 16, 19},
+	}
+	cells := &cellMeans{factors: []string{"a", "b"}, levels: [][]string{{"1", "2"}, {"1", "2", "3"}},
+		subjects: []int{1, 2, 3, 4, 5, 6}, means: data}
+	want := []anovaEffect{
+		{Name: "a", DFn: 1, DFd: 5, F: 2.696629213483143, P: 0.16148325889358417, Eta2p: 0.3503649635036494,
+			Epsilon: math.NaN(), PGG: math.NaN()},
+		{Name: "b", DFn: 2, DFd: 10, F: 112.70334928229667, P: 1.3832666828626477e-07, Eta2p: 0.9575203252032521,
+			Epsilon: 0.6914832990343568, PGG: 9.212240930662081e-06},
+		{Name: "a:b", DFn: 2, DFd: 10, F: 2.738693467336685, P: 0.11259310594176278, Eta2p: 0.35389610389610404,
+			Epsilon: 0.9630124993920527, PGG: 0.11551149193511023},
+	}
+	effects := repeatedMeasuresANOVA(cells)
+	if len(effects) != len(want) {
+		t.Fatalf("%d effects, expected %d", len(effects), len(want))
+	}
+	for index, e := range effects {
+		w := want[index]
+		if e.Name != w.Name || e.DFn != w.DFn || e.DFd != w.DFd {
+			t.Errorf("effect %d is %s with df %g, %g, expected %s with %g, %g", index, e.Name, e.DFn, e.DFd, w.Name, w.DFn, w.DFd)
+			continue
+		}
+		checkClose(t, w.Name+" F", e.F, w.F, 1e-9)
+		checkClose(t, w.Name+" p", e.P, w.P, 1e-9)
+		checkClose(t, w.Name+" eta2p", e.Eta2p, w.Eta2p, 1e-9)
+		checkClose(t, w.Name+" epsilon", e.Epsilon, w.Epsilon, 1e-9)
+		checkClose(t, w.Name+" pGG", e.PGG, w.PGG, 1e-9)
+	}
+}
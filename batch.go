@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the file the model reads its settings for a block from
+const modelInitFile = "QN_ACTR_Model_Initialization.txt"
+
+// the states of a batch job
+const (
+	jobPending = "pending"
+	// the model input has been put in the drop folder and the runner is waiting for its output
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// BatchJob is a model run of a block of a subject in a batch
+type BatchJob struct {
+	Subject int    `json:"subject"`
+	Block   string `json:"block"`
+	State   string `json:"state"`
+	// when the model input was put in the drop folder
+	Put time.Time `json:"put,omitempty"`
+	// when the output was ingested or the job failed
+	Finished time.Time `json:"finished,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// BatchState is the queue of a batch, saved after every change so that a batch can be resumed
+type BatchState struct {
+	Jobs []*BatchJob `json:"jobs"`
+}
+
+// read the state of a batch, or an empty state if it has not been started
+func readBatchState(path string) (*BatchState, error) {
+	state := &BatchState{Jobs: make([]*BatchJob, 0)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return state, nil
+}
+
+func (s *BatchState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// add a job to the queue. a job already in the queue is left as it is unless it failed, when it is retried
+func (s *BatchState) add(subject int, block string) {
+	for _, job := range s.Jobs {
+		if job.Subject == subject && job.Block == block {
+			if job.State == jobFailed {
+				job.State = jobPending
+				job.Error = ""
+			}
+			return
+		}
+	}
+	s.Jobs = append(s.Jobs, &BatchJob{Subject: subject, Block: block, State: jobPending})
+}
+
+// put the failed jobs of the queue back to be run again
+func (s *BatchState) retry() int {
+	retried := 0
+	for _, job := range s.Jobs {
+		if job.State == jobFailed {
+			job.State = jobPending
+			job.Error = ""
+			retried++
+		}
+	}
+	return retried
+}
+
+// the subjects with jobs in the queue, in queue order
+func (s *BatchState) subjects() []int {
+	subjects := make([]int, 0)
+	seen := make(map[int]bool)
+	for _, job := range s.Jobs {
+		if !seen[job.Subject] {
+			seen[job.Subject] = true
+			subjects = append(subjects, job.Subject)
+		}
+	}
+	return subjects
+}
+
+// settings of a batch
+type batchOptions struct {
+	drop    string
+	poll    time.Duration
+	timeout time.Duration
+	ingest  ingestOptions
+}
+
+// the ingestion settings of a job
+func (job *BatchJob) ingestOptions(options batchOptions) ingestOptions {
+	ingest := options.ingest
+	ingest.subject = job.Subject
+	ingest.block = job.Block
+	return ingest
+}
+
+// put the model input of a job in the drop folder. a job whose output could not be ingested without -force is not
+// run, and output of an earlier run left in the folder would be taken for this job's, so both are errors
+func putModelInput(layout *Layout, job *BatchJob, options batchOptions) error {
+	if err := checkIngestTargets(layout, job.ingestOptions(options)); err != nil {
+		return err
+	}
+	for _, file := range ingestFiles(layout, options.drop, options.ingest) {
+		if _, err := os.Stat(file.source); err == nil {
+			return fmt.Errorf("%s is left from an earlier run", file.source)
+		}
+	}
+	src := filepath.Join(layout.TrialDir(job.Subject, job.Block, layout.TrialName(options.ingest.trial)), modelInitFile)
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(options.drop, modelInitFile), data)
+}
+
+// check whether the outputs of a model run are all in the drop folder
+func modelRunReady(layout *Layout, options batchOptions) bool {
+	for _, file := range ingestFiles(layout, options.drop, options.ingest) {
+		if _, err := os.Stat(file.source); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// check whether a job's output was ingested after its input was put, as when the runner stopped
+// between ingesting and saving its state
+func alreadyIngested(layout *Layout, job *BatchJob, options batchOptions) bool {
+	trialDir := layout.TrialDir(job.Subject, job.Block, layout.TrialName(options.ingest.trial))
+	data, err := ioutil.ReadFile(filepath.Join(trialDir, "manifest.json"))
+	if err != nil {
+		return false
+	}
+	var manifest IngestManifest
+	return json.Unmarshal(data, &manifest) == nil && manifest.Ingested.After(job.Put)
+}
+
+// wait for the model to finish a job and ingest its output. the timeout counts from when the
+// waiting starts, so a resumed batch gives a running job the full timeout again
+func waitForModel(layout *Layout, job *BatchJob, options batchOptions) error {
+	deadline := time.Now().Add(options.timeout)
+	for !modelRunReady(layout, options) {
+		if time.Now().After(deadline) {
+			// take the input back if the model never picked it up, so the next job's is not mistaken for it
+			os.Remove(filepath.Join(options.drop, modelInitFile))
+			return fmt.Errorf("no output after %v", options.timeout)
+		}
+		time.Sleep(options.poll)
+	}
+	_, err := ingestModelRun(layout, options.drop, job.ingestOptions(options))
+	return err
+}
+
+// run the jobs of a batch in order, saving the state after every change. a job that fails is recorded as failed and
+// the batch goes on with the next; only failing to save the state stops it
+func runBatch(layout *Layout, state *BatchState, statePath string, options batchOptions) error {
+	for _, job := range state.Jobs {
+		if job.State == jobDone || job.State == jobFailed {
+			continue
+		}
+		var err error
+		if job.State == jobPending {
+			fmt.Printf("putting subject %d %s\n", job.Subject, job.Block)
+			if err = putModelInput(layout, job, options); err == nil {
+				job.State = jobRunning
+				job.Put = time.Now().UTC()
+				if err := state.save(statePath); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err == nil {
+			fmt.Printf("waiting for subject %d %s\n", job.Subject, job.Block)
+			if !alreadyIngested(layout, job, options) {
+				err = waitForModel(layout, job, options)
+			}
+		}
+		job.Finished = time.Now().UTC()
+		if err != nil {
+			job.State = jobFailed
+			job.Error = err.Error()
+			fmt.Fprintf(os.Stderr, "subject %d %s failed: %v\n", job.Subject, job.Block, err)
+		} else {
+			job.State = jobDone
+			fmt.Printf("got subject %d %s\n", job.Subject, job.Block)
+		}
+		if err := state.save(statePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parse a list of block numbers separated by spaces or commas into block names
+func parseBlockList(layout *Layout, list string) ([]string, error) {
+	blocks := make([]string, 0)
+	for _, field := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' }) {
+		number, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("bad block number %q", field)
+		}
+		blocks = append(blocks, layout.BlockName(number))
+	}
+	return blocks, nil
+}
+
+func init() {
+	addCommand(&command{
+		name:  "batch",
+		args:  "[subject...]",
+		short: "run the model on blocks of subjects and aggregate the results",
+		long: `Batch adds a job for each of the -blocks of each subject given to a queue, then runs the
+queued jobs in order: it puts the block's QN_ACTR_Model_Initialization.txt in the drop folder the
+model watches, waits for the model's output to appear there and ingests it as ingest-model does.
+When every job is done the subjects are aggregated and, unless -auto is set, their model
+workload is written as the workload command does.
+
+The queue is saved to the -state file after every step. Running batch again without subjects
+resumes the queue where it stopped; a job that was waiting for the model is waited for again.
+A job fails without running the model if ingesting its output would replace files that are
+already there and -force is not set, as ingest-model would refuse, and it fails if there is no
+output before the -timeout. The batch goes on with the next job after a failure and reports
+the failed jobs at the end, without aggregating. Failed jobs are retried when their subject is
+given again, or all of them with -retry.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			caching := addCacheFlags(flags)
+			var options batchOptions
+			var statePath, blockList string
+			var iterations int
+			var retry bool
+			flags.StringVar(&options.drop, "drop", "", "The folder the model reads its input from and writes its output to")
+			flags.StringVar(&statePath, "state", "", "The file the queue is saved in (default batch.json in the root)")
+			flags.StringVar(&blockList, "blocks", "1 2 3 4 5 6 7 8 9 10 11 12 13 14", "The numbers of the blocks to run for each subject")
+			flags.DurationVar(&options.poll, "poll", 2*time.Second, "How often to check for the model's output")
+			flags.DurationVar(&options.timeout, "timeout", time.Hour, "How long to wait for the output of a block")
+			flags.BoolVar(&options.ingest.auto, "auto", false, "The model writes no utilization results")
+			flags.BoolVar(&options.ingest.force, "force", false, "Replace blocks that have already been ingested")
+			flags.BoolVar(&retry, "retry", false, "Run the failed jobs of the queue again")
+			flags.IntVar(&iterations, "i", 480, "The number of iterations expected when aggregating")
+			return func(args []string) error {
+				if options.drop == "" {
+					return fmt.Errorf("-drop is required")
+				}
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				if statePath == "" {
+					statePath = filepath.Join(layout.Root, "batch.json")
+				}
+				state, err := readBatchState(statePath)
+				if err != nil {
+					return err
+				}
+				blocks, err := parseBlockList(layout, blockList)
+				if err != nil {
+					return err
+				}
+				for _, arg := range args {
+					subject, err := strconv.Atoi(arg)
+					if err != nil {
+						return fmt.Errorf("bad subject number %q", arg)
+					}
+					for _, block := range blocks {
+						state.add(subject, block)
+					}
+				}
+				if retry {
+					fmt.Printf("retrying %d failed jobs\n", state.retry())
+				}
+				if len(state.Jobs) == 0 {
+					return fmt.Errorf("nothing to run, give the subjects to run the model for")
+				}
+				if err := state.save(statePath); err != nil {
+					return err
+				}
+
+				if err := runBatch(layout, state, statePath, options); err != nil {
+					return err
+				}
+				failed := 0
+				for _, job := range state.Jobs {
+					if job.State != jobDone {
+						fmt.Fprintf(os.Stderr, "subject %d %s failed: %s\n", job.Subject, job.Block, job.Error)
+						failed++
+					}
+				}
+				if failed > 0 {
+					return fmt.Errorf("%d of %d jobs failed, run again with -retry to retry them", failed, len(state.Jobs))
+				}
+
+				fmt.Println("ran all blocks, aggregating")
+				aggregation := aggregateOptions{trialNum: -1, iterations: iterations, workers: runtime.NumCPU()}
+				if aggregation.cache, err = caching.cache(layout); err != nil {
+					return err
+				}
+				if !aggregate(layout, state.subjects(), false, "", aggregation) {
+					return fmt.Errorf("aggregation failed")
+				}
+				if !options.ingest.auto && !writeWorkload(layout, state.subjects(), "", aggregation, 0) {
+					return fmt.Errorf("writing workload failed")
+				}
+				return nil
+			}
+		},
+	})
+}
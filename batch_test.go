@@ -0,0 +1,172 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a dataset of subject 1 with model input for each block, a drop folder and a replay directory for the stand-in
+func batchFixture(t *testing.T, blocks ...string) (*Layout, batchOptions, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	layout, err := parseLayout(filepath.Join(dir, "data"), defaultLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range blocks {
+		writeTestFile(t, filepath.Join(layout.TrialDir(1, block, "trial0"), modelInitFile), block+"\n")
+	}
+	replay := filepath.Join(dir, "replay")
+	writeTestFile(t, filepath.Join(replay, "log.txt"), "Start, 1.5, 1\nTargetHit, 2.25, 2\n")
+	writeTestFile(t, filepath.Join(replay, "trace.txt"), "trace\n")
+	options := batchOptions{drop: filepath.Join(dir, "drop"), poll: 5 * time.Millisecond, timeout: 5 * time.Second,
+		ingest: ingestOptions{auto: true}}
+	if err := os.MkdirAll(options.drop, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return layout, options, replay
+}
+
+// run the stand-in on the drop folder until the test ends, counting its runs
+func startStandIn(t *testing.T, options batchOptions, replay string) *int32 {
+	runs := new(int32)
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := os.Stat(filepath.Join(options.drop, modelInitFile)); err != nil {
+				time.Sleep(options.poll)
+				continue
+			}
+			if err := standInRun(options.drop, replay, 0); err != nil {
+				t.Error(err)
+				return
+			}
+			atomic.AddInt32(runs, 1)
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	return runs
+}
+
+func TestRunBatchContinuesAfterFailure(t *testing.T) {
+	layout, options, replay := batchFixture(t, "block1", "block2", "block3")
+	// block2 has been ingested before, so without -force it must fail without the model running
+	writeTestFile(t, filepath.Join(layout.TrialDir(1, "block2", "trial0"), "data.txt"), "old\n")
+	runs := startStandIn(t, options, replay)
+
+	statePath := filepath.Join(layout.Root, "batch.json")
+	state := &BatchState{}
+	for _, block := range []string{"block1", "block2", "block3"} {
+		state.add(1, block)
+	}
+	if err := runBatch(layout, state, statePath, options); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := readBatchState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{jobDone, jobFailed, jobDone}
+	for index, job := range saved.Jobs {
+		if job.State != want[index] {
+			t.Errorf("%s is %s, expected %s (%s)", job.Block, job.State, want[index], job.Error)
+		}
+	}
+	if !strings.Contains(saved.Jobs[1].Error, "already exists") {
+		t.Errorf("block2 failed with %q, expected it to be already ingested", saved.Jobs[1].Error)
+	}
+	if !saved.Jobs[1].Put.IsZero() || atomic.LoadInt32(runs) != 2 {
+		t.Errorf("the model ran %d times and block2 was put at %v, expected 2 runs without block2", atomic.LoadInt32(runs), saved.Jobs[1].Put)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(layout.TrialDir(1, "block2", "trial0"), "data.txt"))
+	if err != nil || string(data) != "old\n" {
+		t.Errorf("block2's data.txt was replaced: %q, %v", data, err)
+	}
+	data, err = ioutil.ReadFile(filepath.Join(layout.TrialDir(1, "block3", "trial0"), "data.txt"))
+	if err != nil || string(data) != "Start, 0000000001500, 1\nTargetHit, 0000000002250, 2\n" {
+		t.Errorf("block3's data.txt is %q, %v", data, err)
+	}
+
+	// with -force the retried job runs
+	options.ingest.force = true
+	if saved.retry() != 1 {
+		t.Fatalf("expected one job to retry")
+	}
+	if err := runBatch(layout, saved, statePath, options); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Jobs[1].State != jobDone || atomic.LoadInt32(runs) != 3 {
+		t.Errorf("after retrying block2 is %s after %d runs, expected done after 3", saved.Jobs[1].State, atomic.LoadInt32(runs))
+	}
+}
+
+func TestRunBatchResumes(t *testing.T) {
+	layout, options, replay := batchFixture(t, "block1", "block2")
+	statePath := filepath.Join(layout.Root, "batch.json")
+
+	// a runner that stopped while the model was running block1
+	state := &BatchState{}
+	state.add(1, "block1")
+	state.add(1, "block2")
+	if err := putModelInput(layout, state.Jobs[0], options); err != nil {
+		t.Fatal(err)
+	}
+	state.Jobs[0].State = jobRunning
+	state.Jobs[0].Put = time.Now().UTC()
+	if err := state.save(statePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// restarting waits for block1 instead of putting it again, then runs block2
+	runs := startStandIn(t, options, replay)
+	resumed, err := readBatchState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runBatch(layout, resumed, statePath, options); err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range resumed.Jobs {
+		if job.State != jobDone {
+			t.Errorf("%s is %s: %s", job.Block, job.State, job.Error)
+		}
+	}
+	if atomic.LoadInt32(runs) != 2 {
+		t.Errorf("the model ran %d times, expected 2", atomic.LoadInt32(runs))
+	}
+
+	// restarting a finished batch runs nothing
+	again, err := readBatchState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runBatch(layout, again, statePath, options); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(runs) != 2 {
+		t.Errorf("the model ran %d times after restarting a finished batch, expected 2", atomic.LoadInt32(runs))
+	}
+	if _, err := os.Stat(filepath.Join(options.drop, modelInitFile)); err == nil {
+		t.Errorf("restarting a finished batch put model input")
+	}
+}
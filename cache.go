@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// resultCache stores the parsed measures of trials in gob files named by a hash of everything
+// the measures depend on, so unchanged trials do not have to be parsed again
+type resultCache struct {
+	dir string
+	// the version of the measures, metricVersion except in tests
+	version int
+}
+
+// the files besides data.txt whose contents go into a trial's cache key
+func cacheKeyFiles(layout *Layout, job trialJob) []string {
+	return []string{
+		filepath.Join(layout.BlockDir(job.subject, job.block), "block.txt"),
+		filepath.Join(layout.TrialDir(job.subject, job.block, job.trial), "task.txt"),
+	}
+}
+
+// compute the cache key of a trial from the metric version, the expected number of iterations
+// and the contents of its data, block and task files
+func (c *resultCache) key(layout *Layout, job trialJob, data []byte, iterations int) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "metric version %d\niterations %d\n", c.version, iterations)
+	fmt.Fprintf(hash, "data.txt %d\n", len(data))
+	hash.Write(data)
+	for _, path := range cacheKeyFiles(layout, job) {
+		contents, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(hash, "%s missing\n", filepath.Base(path))
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s %d\n", filepath.Base(path), len(contents))
+		hash.Write(contents)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (c *resultCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".gob")
+}
+
+// get the cached measures of a key if there are any
+func (c *resultCache) load(key string) (map[string][]float64, bool) {
+	file, err := os.Open(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+	var times map[string][]float64
+	if gob.NewDecoder(file).Decode(&times) != nil {
+		return nil, false
+	}
+	return times, true
+}
+
+// store the measures of a key. the file is written atomically so that concurrent runs never see a partial entry
+func (c *resultCache) store(key string, times map[string][]float64) error {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(times); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path(key), buffer.Bytes())
+}
+
+// get the measures of a trial from the cache, or parse and cache them
+func (c *resultCache) trialTimes(layout *Layout, job trialJob, iterations int) (map[string][]float64, bool, error) {
+	data, err := readTrialData(layout, job.subject, job.block, job.trial)
+	if err != nil {
+		return nil, false, err
+	}
+	key, err := c.key(layout, job, data, iterations)
+	if err != nil {
+		return nil, false, err
+	}
+	if times, ok := c.load(key); ok {
+		return times, true, nil
+	}
+
+	lines, err := normaliseEvents(string(data))
+	if err != nil {
+		return nil, false, err
+	}
+	times, err := trialTimes(lines, job.levels, iterations)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := c.store(key, times); err != nil {
+		fmt.Fprintf(os.Stderr, "could not cache results of %s: %v\n", layout.TrialDir(job.subject, job.block, job.trial), err)
+	}
+	return times, false, nil
+}
+
+// remove every entry of the cache
+func (c *resultCache) clear() error {
+	return os.RemoveAll(c.dir)
+}
+
+// flags controlling the result cache
+type cacheFlags struct {
+	dir     string
+	disable bool
+	clear   bool
+}
+
+func addCacheFlags(flags *flag.FlagSet) *cacheFlags {
+	c := new(cacheFlags)
+	flags.StringVar(&c.dir, "cache", "", "The directory of the result cache (default .cache in the root)")
+	flags.BoolVar(&c.disable, "no-cache", false, "Parse every trial instead of using cached results")
+	flags.BoolVar(&c.clear, "clear-cache", false, "Empty the result cache before running")
+	return c
+}
+
+// get the cache selected by the flags, or nil if caching is disabled
+func (c *cacheFlags) cache(layout *Layout) (*resultCache, error) {
+	dir := c.dir
+	if dir == "" {
+		dir = filepath.Join(layout.Root, ".cache")
+	}
+	cache := &resultCache{dir, metricVersion}
+	if c.clear {
+		if err := cache.clear(); err != nil {
+			return nil, err
+		}
+	}
+	if c.disable {
+		return nil, nil
+	}
+	return cache, nil
+}
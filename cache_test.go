@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// write a file, creating its directory
+func writeTestFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	layout, err := parseLayout(filepath.Join(dir, "data"), defaultLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := trialJob{subject: 1, block: "block0", trial: "trial0", levels: &IVLevels{}}
+	files := map[string]string{
+		filepath.Join(layout.TrialDir(1, "block0", "trial0"), "data.txt"): "Start, 1.5, 1\n",
+		filepath.Join(layout.BlockDir(1, "block0"), "block.txt"):          "block\n",
+		filepath.Join(layout.TrialDir(1, "block0", "trial0"), "task.txt"): "task\n",
+	}
+	for path, contents := range files {
+		writeTestFile(t, path, contents)
+	}
+	cache := &resultCache{filepath.Join(dir, "cache"), metricVersion}
+	key := func(c *resultCache, iterations int) string {
+		t.Helper()
+		data, err := readTrialData(layout, 1, "block0", "trial0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := c.key(layout, job, data, iterations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	original := key(cache, 10)
+	if _, ok := cache.load(original); ok {
+		t.Fatalf("an empty cache has an entry")
+	}
+	times := map[string][]float64{"complete": {1.5, 2.25}, "target": {0.75}}
+	if err := cache.store(original, times); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := cache.load(original); !ok || !reflect.DeepEqual(got, times) {
+		t.Errorf("the cache returned %v, %v for %v", got, ok, times)
+	}
+	// an unchanged trial is not parsed again, so the stored measures come back as they are
+	if got, cached, err := cache.trialTimes(layout, job, 10); err != nil || !cached || !reflect.DeepEqual(got, times) {
+		t.Errorf("an unchanged trial got %v, cached %v, error %v", got, cached, err)
+	}
+
+	if key(cache, 10) != original {
+		t.Errorf("the key of an unchanged trial changed")
+	}
+	if key(cache, 11) == original {
+		t.Errorf("another number of iterations has the same key")
+	}
+	if key(&resultCache{cache.dir, metricVersion + 1}, 10) == original {
+		t.Errorf("another metric version has the same key")
+	}
+	for path, contents := range files {
+		writeTestFile(t, path, contents+"changed\n")
+		if changed := key(cache, 10); changed == original {
+			t.Errorf("changing %s did not change the key", filepath.Base(path))
+		} else if _, ok := cache.load(changed); ok {
+			t.Errorf("changing %s did not miss the cache", filepath.Base(path))
+		}
+		writeTestFile(t, path, contents)
+	}
+	if key(cache, 10) != original {
+		t.Errorf("restoring the files did not restore the key")
+	}
+	if err := os.Remove(filepath.Join(layout.TrialDir(1, "block0", "trial0"), "task.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if key(cache, 10) == original {
+		t.Errorf("removing task.txt did not change the key")
+	}
+}
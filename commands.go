@@ -0,0 +1,353 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// a subcommand of the tool
+type command struct {
+	// the name used to invoke the command
+	name string
+	// the arguments accepted after the flags, if any
+	args string
+	// a one line description for the command list
+	short string
+	// a longer description for the command's help
+	long string
+	// register the command's flags and return the function that runs it
+	setup func(flags *flag.FlagSet) func(args []string) error
+}
+
+// the commands of the tool, by name
+var commands = map[string]*command{}
+
+func addCommand(c *command) {
+	commands[c.name] = c
+}
+
+func init() {
+	addCommand(&command{
+		name:  "aggregate",
+		short: "write the per-iteration results of subjects to r1.txt files",
+		long: `Aggregate parses the data of every trial of a subject and writes one row per iteration
+to r1.txt in the subject directory. With -all every subject in the root is aggregated and a
+combined file with subject, block, trial and iteration columns is written as well.
+
+The -where expression restricts the output to the blocks and iterations for which it is true.
+It may use numbers, "strings", true and false, the operators == != < <= > >= && || ! and
+parentheses, and these variables:
+
+  subject, block, trial, iteration          where the iteration came from
+  practice, targets, speed, difficulty      the block conditions
+  oprange                                   "low", "high" or "none"
+  addition, target, complete, hits, friendHits, shots, hovers, op1, op2
+                                            the measures of the iteration
+
+Blocks whose conditions fail an expression that only uses subject, block and the conditions
+are skipped without being parsed.
+
+With -outliers each row also says whether the iteration is an outlier among the subject's
+iterations under the same conditions, and by which rule: sd (more than 3 standard deviations
+from the mean), iqr (beyond the 1.5 interquartile range fences of a box plot, as analysis.R's
+removeOutliers) or mad (more than 3 scaled median absolute deviations from the median). A
+different cutoff follows a colon, as in sd:2.5, and -outlier-measure chooses the measure tested.`,
+		setup: setupAggregate,
+	})
+	addCommand(&command{
+		name:  "events",
+		short: "print the events of trials with times relative to the trial start",
+		long: `Events prints the events of the selected trials with each time stamp replaced by the
+number of seconds since the start of the trial, as the aggregator sees them.`,
+		setup: trialCommand(func(layout *Layout, job trialJob) error {
+			lines, err := readTrialLines(layout, job.subject, job.block, job.trial)
+			if err != nil {
+				return err
+			}
+			fmt.Println(strings.Join(lines, "\n"))
+			return nil
+		}),
+	})
+	addCommand(&command{
+		name:  "times",
+		short: "print the task completion times of trials",
+		long:  `Times prints the task completion time of each iteration of the selected trials.`,
+		setup: trialCommand(func(layout *Layout, job trialJob) error {
+			lines, err := readTrialLines(layout, job.subject, job.block, job.trial)
+			if err != nil {
+				return err
+			}
+			printHitAndAdditionTimes(lines, int(math.Ceil(float64(job.levels.TargetNumber)/2)))
+			return nil
+		}),
+	})
+	addCommand(&command{
+		name:  "describe",
+		short: "print the task description of trials",
+		long:  `Describe prints the number of targets, speed and operand range recorded in task.txt of the selected trials.`,
+		setup: trialCommand(func(layout *Layout, job trialJob) error {
+			return printTaskData(layout, job.subject, job.block, job.trial)
+		}),
+	})
+	addCommand(&command{
+		name:  "accuracy",
+		short: "print the clicks, misses and hits of trials",
+		long:  `Accuracy prints the number of clicks, missed clicks and target hits in the selected trials.`,
+		setup: trialCommand(func(layout *Layout, job trialJob) error {
+			lines, err := readTrialLines(layout, job.subject, job.block, job.trial)
+			if err != nil {
+				return err
+			}
+			printAccuracy(strings.Join(lines, "\n"))
+			return nil
+		}),
+	})
+	addCommand(&command{
+		name:  "responses",
+		short: "print the oral response times of trials",
+		long:  `Responses prints the oral response times recorded in responses.txt of the selected trials.`,
+		setup: trialCommand(func(layout *Layout, job trialJob) error {
+			printResponseTimes(layout, job.subject, job.block, job.trial)
+			return nil
+		}),
+	})
+	addCommand(&command{
+		name:  "validate",
+		short: "check that trials can be aggregated without writing anything",
+		long: `Validate parses every selected trial as aggregate would and reports the trials that
+could not be aggregated. It exits with a non-zero status if any trial has a problem.`,
+		setup: setupValidate,
+	})
+}
+
+// flags locating a dataset
+type datasetFlags struct {
+	root     string
+	template string
+	subjects string
+}
+
+func addDatasetFlags(flags *flag.FlagSet) *datasetFlags {
+	d := new(datasetFlags)
+	flags.StringVar(&d.root, "root", "output", "The directory containing the subject directories")
+	flags.StringVar(&d.template, "layout", defaultLayout, "The path template of trial directories under the root")
+	flags.StringVar(&d.subjects, "subjects", "", "The registry of subjects (default subjects.csv or subjects.json in the root)")
+	return d
+}
+
+// get the layout of the dataset with its registry of subjects, warning about subjects without an entry
+func (d *datasetFlags) layout() (*Layout, error) {
+	layout, err := parseLayout(d.root, d.template)
+	if err != nil {
+		return nil, err
+	}
+	if layout.registryPath, layout.registry, err = findSubjectRegistry(d.root, d.subjects); err != nil {
+		return nil, err
+	}
+	if layout.registry != nil {
+		if missing := layout.unregisteredSubjects(); len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "subject directories without an entry in %s: %v\n", layout.registryPath, missing)
+		}
+	}
+	return layout, nil
+}
+
+// flags selecting subjects, blocks and trials
+type selectionFlags struct {
+	subject int
+	all     bool
+	// use every subject when no -s is given, as the analyses across subjects do
+	defaultAll bool
+	options    aggregateOptions
+}
+
+// add the selection flags of the commands that write the results of a subject, which default to subject 5 as the
+// single subject version of the tool did
+func addSelectionFlags(flags *flag.FlagSet) *selectionFlags {
+	s := new(selectionFlags)
+	flags.IntVar(&s.subject, "s", 5, "The number of the subject")
+	addSelectionOptionFlags(flags, s)
+	return s
+}
+
+// add the selection flags of the analyses across subjects, which use every subject unless -s names one
+func addAnalysisSelectionFlags(flags *flag.FlagSet) *selectionFlags {
+	s := &selectionFlags{defaultAll: true}
+	flags.IntVar(&s.subject, "s", 0, "The number of a single subject to analyse (default every subject)")
+	addSelectionOptionFlags(flags, s)
+	return s
+}
+
+func addSelectionOptionFlags(flags *flag.FlagSet, s *selectionFlags) {
+	flags.BoolVar(&s.all, "all", false, "Use every subject in the root")
+	flags.StringVar(&s.options.blockName, "block", "", "Specify a block to output")
+	flags.IntVar(&s.options.trialNum, "trial", -1, "Specify a trial to output")
+	flags.IntVar(&s.options.iterations, "i", 12, "The number of iterations expected")
+	flags.IntVar(&s.options.workers, "j", runtime.NumCPU(), "The number of trials to process at once")
+}
+
+// get the selected subjects
+func (s *selectionFlags) subjects(layout *Layout) []int {
+	if s.all || s.defaultAll && s.subject == 0 {
+		return layout.Subjects()
+	}
+	return []int{s.subject}
+}
+
+// get the selected trials of all selected subjects
+func (s *selectionFlags) jobs(layout *Layout) []trialJob {
+	jobs := make([]trialJob, 0)
+	for _, subject := range s.subjects(layout) {
+		jobs = append(jobs, subjectJobs(layout, subject, s.options)...)
+	}
+	return jobs
+}
+
+func setupAggregate(flags *flag.FlagSet) func(args []string) error {
+	dataset := addDatasetFlags(flags)
+	selection := addSelectionFlags(flags)
+	caching := addCacheFlags(flags)
+	outliers := addOutlierFlags(flags)
+	var out, where string
+	flags.StringVar(&out, "out", "", "Where to write results: a file, a directory, or - for stdout (default r1.txt in the subject directory)")
+	flags.StringVar(&where, "where", "", "Only aggregate the blocks and iterations for which an expression is true, e.g. 'speed == 200 && oprange == \"high\" && !practice'")
+	return func(args []string) error {
+		layout, err := dataset.layout()
+		if err != nil {
+			return err
+		}
+		if where != "" {
+			if selection.options.where, err = parseFilter(where); err != nil {
+				return err
+			}
+		}
+		if selection.options.cache, err = caching.cache(layout); err != nil {
+			return err
+		}
+		if selection.options.outliers, err = outliers.rule(); err != nil {
+			return err
+		}
+		if !aggregate(layout, selection.subjects(layout), selection.all, out, selection.options) {
+			return fmt.Errorf("aggregation failed")
+		}
+		return nil
+	}
+}
+
+func setupValidate(flags *flag.FlagSet) func(args []string) error {
+	dataset := addDatasetFlags(flags)
+	selection := addSelectionFlags(flags)
+	caching := addCacheFlags(flags)
+	return func(args []string) error {
+		layout, err := dataset.layout()
+		if err != nil {
+			return err
+		}
+		if selection.options.cache, err = caching.cache(layout); err != nil {
+			return err
+		}
+		jobs := selection.jobs(layout)
+		problems := 0
+		for _, result := range runJobs(layout, jobs, selection.options) {
+			if result.err != nil {
+				fmt.Println(result.err)
+				problems++
+			}
+		}
+		fmt.Printf("%d trials checked, %d with problems\n", len(jobs), problems)
+		if problems > 0 {
+			return fmt.Errorf("%d trials could not be aggregated", problems)
+		}
+		return nil
+	}
+}
+
+// make the setup of a command that runs a function on each selected trial
+func trialCommand(run func(layout *Layout, job trialJob) error) func(flags *flag.FlagSet) func(args []string) error {
+	return func(flags *flag.FlagSet) func(args []string) error {
+		dataset := addDatasetFlags(flags)
+		selection := addSelectionFlags(flags)
+		return func(args []string) error {
+			layout, err := dataset.layout()
+			if err != nil {
+				return err
+			}
+			failed := false
+			for _, job := range selection.jobs(layout) {
+				// print subject and trial
+				fmt.Printf("subject, %d, block %s, trial, %s\n", job.subject, job.block, job.trial)
+				if err := run(layout, job); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					failed = true
+				}
+			}
+			if failed {
+				return fmt.Errorf("some trials failed")
+			}
+			return nil
+		}
+	}
+}
+
+// print the list of commands
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: convert_times <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].short)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, `use "convert_times help <command>" for the flags of a command`)
+}
+
+// run the command named by the first argument and return the exit status
+func runCommand(args []string) int {
+	// flags without a command run aggregate, as convert_times did before it had commands
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		args = append([]string{"aggregate"}, args...)
+	}
+	name := args[0]
+	if name == "help" {
+		if len(args) < 2 {
+			printUsage()
+			return 0
+		}
+		name = args[1]
+		args = []string{name, "-h"}
+	}
+	c, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage()
+		return 2
+	}
+
+	flags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	run := c.setup(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s\n\n%s\n\nflags:\n", strings.TrimSpace("convert_times "+c.name+" [flags] "+c.args), c.long)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if err := run(flags.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", c.name, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a condition of the experiment as analysis.R's getVertCase sees it. levels that do not apply to
+// the type of task are "-"
+type condition struct {
+	Type, Speed, Difficulty, OpRange string
+}
+
+var taskTypeOrder = map[string]int{"addition": 0, "targeting": 1, "main": 2}
+
+// get the condition of an observation
+func observationCondition(o Observation) condition {
+	c := condition{o.Type, strconv.Itoa(o.Levels.TargetSpeed), strconv.Itoa(o.Levels.TargetDifficulty), opRangeLevel(o.Levels)}
+	if o.Type == "addition" {
+		c.Speed, c.Difficulty = "-", "-"
+	}
+	if o.Type == "targeting" {
+		c.OpRange = "-"
+	}
+	return c
+}
+
+func (c condition) less(other condition) bool {
+	if c.Type != other.Type {
+		return taskTypeOrder[c.Type] < taskTypeOrder[other.Type]
+	}
+	if c.OpRange != other.OpRange {
+		return c.OpRange > other.OpRange // low before high
+	}
+	if c.Speed != other.Speed {
+		return c.Speed < other.Speed
+	}
+	return c.Difficulty < other.Difficulty
+}
+
+// the mean of a measure under a condition with its confidence interval, as summarySE computes them
+type conditionMean struct {
+	N    int
+	Mean float64
+	// the half width of the confidence interval
+	CI float64
+}
+
+func summariseValues(values []float64, confidence float64) conditionMean {
+	m, n := mean(values)
+	ci := sd(values) / math.Sqrt(float64(n)) * tQuantile(confidence/2+0.5, float64(n-1))
+	return conditionMean{n, m, ci}
+}
+
+// the mean of a measure under each condition of non-practice observations
+func conditionMeans(observations []Observation, measure string, confidence float64) map[condition]conditionMean {
+	values := make(map[condition][]float64)
+	for _, o := range observations {
+		if o.Levels.Practice {
+			continue
+		}
+		c := observationCondition(o)
+		values[c] = append(values[c], o.measure(measure))
+	}
+	means := make(map[condition]conditionMean)
+	for c, v := range values {
+		if summary := summariseValues(v, confidence); summary.N > 0 {
+			means[c] = summary
+		}
+	}
+	return means
+}
+
+// the fit of the model to the people for a measure over the conditions both have
+type modelFit struct {
+	Conditions int
+	RMSE, R, P float64
+}
+
+func fitModel(human, model map[condition]conditionMean) modelFit {
+	xs, ys := make([]float64, 0), make([]float64, 0)
+	squares := 0.0
+	for c, h := range human {
+		if m, ok := model[c]; ok {
+			xs = append(xs, h.Mean)
+			ys = append(ys, m.Mean)
+			squares += (m.Mean - h.Mean) * (m.Mean - h.Mean)
+		}
+	}
+	fit := modelFit{Conditions: len(xs), RMSE: math.NaN()}
+	if len(xs) > 0 {
+		fit.RMSE = math.Sqrt(squares / float64(len(xs)))
+	}
+	fit.R, fit.P, _ = correlation(xs, ys)
+	return fit
+}
+
+// write the comparison tables: the means of each measure under each condition, then the fit of each measure
+func writeComparison(w io.Writer, human, model []Observation, measures []string, confidence float64) {
+	fits := make([]modelFit, len(measures))
+	fmt.Fprintln(w, "measure, type, speed, difficulty, oprange, humanN, human, humanCI, modelN, model, modelCI, difference")
+	for index, measure := range measures {
+		humanMeans := conditionMeans(human, measure, confidence)
+		modelMeans := conditionMeans(model, measure, confidence)
+		conditions := make([]condition, 0)
+		for c := range humanMeans {
+			conditions = append(conditions, c)
+		}
+		for c := range modelMeans {
+			if _, ok := humanMeans[c]; !ok {
+				conditions = append(conditions, c)
+			}
+		}
+		sort.Slice(conditions, func(i, j int) bool { return conditions[i].less(conditions[j]) })
+		for _, c := range conditions {
+			h, okHuman := humanMeans[c]
+			m, okModel := modelMeans[c]
+			if !okHuman {
+				h = conditionMean{0, math.NaN(), math.NaN()}
+			}
+			if !okModel {
+				m = conditionMean{0, math.NaN(), math.NaN()}
+			}
+			fmt.Fprintf(w, "%s, %s, %s, %s, %s, %d, %s, %s, %d, %s, %s, %s\n", measure, c.Type, c.Speed, c.Difficulty, c.OpRange,
+				h.N, formatStat(h.Mean), formatStat(h.CI), m.N, formatStat(m.Mean), formatStat(m.CI), formatStat(m.Mean-h.Mean))
+		}
+		fits[index] = fitModel(humanMeans, modelMeans)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "measure, conditions, rmse, r, p")
+	for index, measure := range measures {
+		fit := fits[index]
+		fmt.Fprintf(w, "%s, %d, %s, %s, %s\n", measure, fit.Conditions, formatStat(fit.RMSE), formatStat(fit.R), formatStat(fit.P))
+	}
+}
+
+func init() {
+	addCommand(&command{
+		name:  "compare",
+		short: "compare the model's results with people's under each condition",
+		long: `Compare parses every subject of a human dataset and a model dataset, which may be the same
+directory, and writes two tables. The first has the mean of each measure under each condition
+(type of task, speed, difficulty and operand range) for people and for the model, with the
+number of iterations, the half width of the confidence interval and the model's difference from
+people. The second has the root mean square error and the correlation of the condition means of
+each measure. Practice blocks are left out.
+
+Trials are told apart by how they were recorded, not by where they are: a trial is the model's
+if ingest-model wrote its manifest.json or it has the model's trace.txt, and a person's otherwise.
+Trials of the other source in either dataset are ignored.
+
+The measures are those of r1.txt and misses, accuracy (hits per shot) and concurrency, how much
+the two tasks of the dual task overlapped given the subject's single task times.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			caching := addCacheFlags(flags)
+			var humanRoot, modelRoot, template, measureList, out string
+			var humanIterations, modelIterations, workers int
+			var confidence float64
+			flags.StringVar(&humanRoot, "human", "output", "The directory containing the subject directories of people")
+			flags.StringVar(&modelRoot, "model", "output", "The directory containing the subject directories of model runs")
+			flags.StringVar(&template, "layout", defaultLayout, "The path template of trial directories under the roots")
+			flags.IntVar(&humanIterations, "human-i", 12, "The number of iterations expected in a trial of a person")
+			flags.IntVar(&modelIterations, "model-i", 480, "The number of iterations expected in a model run")
+			flags.IntVar(&workers, "j", runtime.NumCPU(), "The number of trials to process at once")
+			flags.StringVar(&measureList, "measures", "complete,addition,target,concurrency,accuracy", "The measures to compare, separated by commas")
+			flags.Float64Var(&confidence, "ci", 0.95, "The confidence level of the intervals")
+			flags.StringVar(&out, "out", "-", "The file to write, or - for stdout")
+			return func(args []string) error {
+				measures := strings.Split(measureList, ",")
+				for index, measure := range measures {
+					measures[index] = strings.TrimSpace(measure)
+					if !isMeasure(measures[index]) {
+						return fmt.Errorf("unknown measure %q", measures[index])
+					}
+				}
+				load := func(root, source string, iterations int) ([]Observation, error) {
+					layout, err := parseLayout(root, template)
+					if err != nil {
+						return nil, err
+					}
+					if layout.registryPath, layout.registry, err = findSubjectRegistry(root, ""); err != nil {
+						return nil, err
+					}
+					options := aggregateOptions{trialNum: -1, iterations: iterations, workers: workers}
+					if options.cache, err = caching.cache(layout); err != nil {
+						return nil, err
+					}
+					return loadObservations(layout, layout.Subjects(), source, options)
+				}
+				human, err := load(humanRoot, "human", humanIterations)
+				if err != nil {
+					return err
+				}
+				model, err := load(modelRoot, "model", modelIterations)
+				if err != nil {
+					return err
+				}
+
+				var w io.WriteCloser = nopWriteCloser{os.Stdout}
+				if out != "-" {
+					file, err := os.Create(out)
+					if err != nil {
+						return err
+					}
+					w = file
+				}
+				writeComparison(w, human, model, measures, confidence)
+				return w.Close()
+			}
+		},
+	})
+}
@@ -3,12 +3,13 @@ package main
 import (
 	"bufio"
 	"encoding/json"
-	"flag"
 	"fmt"
 	"github.com/skelterjohn/geom"
+	"io"
 	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -37,9 +38,9 @@ func listToString(list []float64) string {
 	return strings.Join(stringslist, ",")
 }
 
-func printResponseTimes(subject int, block, trial string) {
+func printResponseTimes(layout *Layout, subject int, block, trial string) {
 	// read oral response times if they exist
-	file, err := os.Open(fmt.Sprintf("output/subject%d/%s/%s/responses.txt", subject, block, trial))
+	file, err := os.Open(filepath.Join(layout.TrialDir(subject, block, trial), "responses.txt"))
 	if err == nil {
 		// get the contents of the file
 		contents, _ := ioutil.ReadAll(bufio.NewReader(file))
@@ -148,6 +149,10 @@ func makeTargets(lines []string, startIndex int) []Target {
 	return targetObjs
 }
 
+// the version of the measures computed by parseResults. bump it whenever the parsing changes
+// so that results cached by older versions are parsed again
+const metricVersion = 1
+
 func parseResults(lines []string, targets int) map[string][]float64 {
 	// define regexes for target hits and starts
 	hitRE, _ := regexp.Compile(`TargetHit, ([\d\.]+), `)
@@ -400,31 +405,42 @@ func printHitAndAdditionTimes(lines []string, targets int) {
 	fmt.Println()
 }
 
-func getTaskDataObject(subject int, block, trial string) map[string]interface{} {
+func getTaskDataObject(layout *Layout, subject int, block, trial string) (map[string]interface{}, error) {
 	// read task description
-	file, _ := os.Open(fmt.Sprintf("output/subject%d/%s/%s/task.txt", subject, block, trial))
+	file, err := os.Open(filepath.Join(layout.TrialDir(subject, block, trial), "task.txt"))
+	if err != nil {
+		return nil, err
+	}
 	contents, _ := ioutil.ReadAll(bufio.NewReader(file))
 	file.Close()
 	trialDesc := string(contents)
 
 	// discard "start trial: "
+	if !strings.HasPrefix(trialDesc, "start trial: ") {
+		return nil, fmt.Errorf("%s does not start with \"start trial: \"", file.Name())
+	}
 	trialDesc = trialDesc[len("start trial: "):len(trialDesc)]
 
 	// parse json
 	decoder := json.NewDecoder(strings.NewReader(trialDesc))
 	var descObj map[string]interface{}
-	decoder.Decode(&descObj)
+	if err := decoder.Decode(&descObj); err != nil {
+		return nil, fmt.Errorf("%s: %v", file.Name(), err)
+	}
 
-	return descObj
+	return descObj, nil
 }
-func printTaskData(subject int, block, trial string) {
-	descObj := getTaskDataObject(subject, block, trial)
+func printTaskData(layout *Layout, subject int, block, trial string) error {
+	descObj, err := getTaskDataObject(layout, subject, block, trial)
+	if err != nil {
+		return err
+	}
 
 	// print number of targets
 	if numTargets, ok := descObj["numTargets"]; ok {
 		fmt.Printf("number of targets, %d\n", int(numTargets.(float64)))
 	} else {
-		fmt.Printf("no number of targets found")
+		fmt.Printf("no number of targets found\n")
 	}
 	// print speed
 	if speed, ok := descObj["targetDist"]; ok {
@@ -435,9 +451,10 @@ func printTaskData(subject int, block, trial string) {
 		fmt.Printf("op range, %v\n", opRange)
 	}
 
+	return nil
 }
 
-func printRHeader(file *os.File) {
+func printRHeader(file io.Writer) {
 	// TODO we should really read this from the file in case any of the parameters change
 	//fmt.Println("targets, speed, oprange, et1, et2, et3, et4, et5, et6, et7, et8, et9, et10, et11, et12")
 	fmt.Fprintln(file, "practice, targets, speed, oprange, difficulty, addition, target, complete, hits, friendHits, shots, hovers, op1, op2")
@@ -459,31 +476,6 @@ func printAccuracy(contents string) {
 	fmt.Printf("hits, %d\n", hits)
 }
 
-func trialsInDir(dirname string) []string {
-	return getDirsInDirWithPrefix(dirname, "trial")
-}
-
-func blocksInDir(dirname string) []string {
-	return getDirsInDirWithPrefix(dirname, "block")
-}
-func getDirsInDirWithPrefix(dirname, prefix string) []string {
-	// get file info slice
-	fileInfos, err := ioutil.ReadDir(dirname)
-	if err != nil {
-		fmt.Errorf("Could not read contents of %s", dirname)
-		return nil
-	}
-	// make name slice
-	names := make([]string, 0, len(fileInfos))
-	// fill name slice
-	for _, fi := range fileInfos {
-		if strings.HasPrefix(fi.Name(), prefix) {
-			names = append(names, fi.Name())
-		}
-	}
-	return names
-}
-
 type IVLevels struct {
 	TargetNumber       int
 	TargetSpeed        int
@@ -492,166 +484,104 @@ type IVLevels struct {
 	Practice           bool
 }
 
-func getBlockIVLevels(subject int, block string) *IVLevels {
-	// get file object
-	if fi, err := os.Open(fmt.Sprintf("output/subject%d/%s/block.txt", subject, block)); err == nil {
-		//decoder := json.NewDecoder(bufio.NewReader(fi))
-		bytes, _ := ioutil.ReadAll(bufio.NewReader(fi))
-
-		var levels *IVLevels = new(IVLevels)
-		//if decoder.Decode(&levels) == nil {
-		if json.Unmarshal(bytes, levels) == nil {
-			return levels
-		} /* else {
-			fmt.Printf("could not decode levels from %s", string(bytes))
-		}*/
-	} else {
-		fmt.Printf("could not open block desc file output/subject%d/%s/block.txt", subject, block)
+// read the IV levels of a block from its block.txt
+func readBlockIVLevels(layout *Layout, subject int, block string) (*IVLevels, error) {
+	bytes, err := ioutil.ReadFile(filepath.Join(layout.BlockDir(subject, block), "block.txt"))
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	var levels *IVLevels = new(IVLevels)
+	if err := json.Unmarshal(bytes, levels); err != nil {
+		return nil, fmt.Errorf("could not decode levels from %s: %v", filepath.Join(layout.BlockDir(subject, block), "block.txt"), err)
+	}
+	return levels, nil
 }
 
-func main() {
-
-	var subject int
-	var practice bool
-	var blockName string
-	var trialNum int
-	var numIterations int
-
-	// get subject and trial from command line ifassed
-	flag.IntVar(&subject, "s", 5, "The number of the subject")
-	//	flag.IntVar(&trial, "t", 1, "The trial number")
-	//flag.BoolVar(&practice, "practice", false, "Set to produce variables for practice blocks")
-	flag.BoolVar(&practice, "practice", false, "set to practice")
-	flag.StringVar(&blockName, "block", "", "Specify a block to output")
-	flag.IntVar(&trialNum, "trial", -1, "Specify a trial to output")
-	flag.IntVar(&numIterations, "i", 12, "The number of iterations expected")
-	flag.Parse()
-
-	var blocks []string
-	if blockName == "" {
-		blocks = blocksInDir(fmt.Sprintf("output/subject%d", subject))
-	} else {
-		blocks = []string{blockName}
+func getBlockIVLevels(layout *Layout, subject int, block string) *IVLevels {
+	levels, err := readBlockIVLevels(layout, subject, block)
+	if _, ok := err.(*os.PathError); ok {
+		fmt.Fprintf(os.Stderr, "could not open block desc file %s\n", filepath.Join(layout.BlockDir(subject, block), "block.txt"))
 	}
+	return levels
+}
 
-	// create output file object
-	result_file, err := os.Create(fmt.Sprintf("output/subject%d/r1.txt", subject))
+// read the contents of a trial's data file
+func readTrialData(layout *Layout, subject int, block, trial string) ([]byte, error) {
+	// make file object
+	file, err := os.Open(filepath.Join(layout.TrialDir(subject, block, trial), "data.txt"))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error creating file output/subject%d/r1.txt", subject)
-		panic("error creating file")
+		return nil, err
 	}
 
-	// print header
-	printRHeader(result_file)
-
-	var levels *IVLevels
-
-	for _, block := range blocks {
-
-		// get block IV levels now if we're not in practice block
-		levels = getBlockIVLevels(subject, block)
-
-		//	trials := []int{1,2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
-		var trials []string
-		if trialNum == -1 {
-			trials = trialsInDir(fmt.Sprintf("output/subject%d/%s", subject, block))
-		} else {
-			trials = []string{fmt.Sprintf("trial%d", trialNum)}
-		}
-
-		for _, trial := range trials {
-
-			// print subject and trial
-			//fmt.Printf("subject, %d, block %s, trial, %s\n", subject, block, trial)
-
-			// make file object
-			file, _ := os.Open(fmt.Sprintf("output/subject%d/%s/%s/data.txt", subject, block, trial))
-
-			// get file contents
-			buffer, _ := ioutil.ReadAll(bufio.NewReader(file))
-			contents := string(buffer)
-
-			// close file
-			file.Close()
-
-			// read and print task data
-			//printTaskData(subject, block, trial)
-
-			// define rege for start of trial
-			trial_start_regex, _ := regexp.Compile(`TrialStart, (\d{13})`)
-
-			// get string of trial start time
-			fmt.Printf("matching trial start in block %s\n", block)
-			stamp_string := trial_start_regex.FindStringSubmatch(contents)[1]
-
-			// get time object for start time
-			startDate := datetimeFromString(stamp_string)
+	// get file contents
+	buffer, err := ioutil.ReadAll(bufio.NewReader(file))
+	// close file
+	file.Close()
+	return buffer, err
+}
 
-			// define function for replacing time stamps
-			replaceFunc := func(match string) string {
-				diff := datetimeFromString(strings.TrimSpace(match)).Sub(startDate)
-				return fmt.Sprintf(" %f", diff.Seconds())
-			}
+// read a trial's data file and replace its time stamps with seconds since the start of the trial
+func readTrialLines(layout *Layout, subject int, block, trial string) ([]string, error) {
+	buffer, err := readTrialData(layout, subject, block, trial)
+	if err != nil {
+		return nil, err
+	}
+	return normaliseEvents(string(buffer))
+}
 
-			// define regex for time stamp
-			date_regex, _ := regexp.Compile(` \d{13}`)
-			// replace times stamps
-			diffTimes := date_regex.ReplaceAllStringFunc(contents, replaceFunc)
+// replace the time stamps of the events in the contents of a data file with seconds since the start of the trial
+func normaliseEvents(contents string) ([]string, error) {
+	// define rege for start of trial
+	trial_start_regex, _ := regexp.Compile(`TrialStart, (\d{13})`)
 
-			// print accuracy info
-			//printAccuracy(diffTimes)
+	// get string of trial start time
+	match := trial_start_regex.FindStringSubmatch(contents)
+	if match == nil {
+		return nil, fmt.Errorf("no trial start found")
+	}
+	stamp_string := match[1]
 
-			// read response time data and print
-			//printResponseTimes(subject, block, trial)
+	// get time object for start time
+	startDate := datetimeFromString(stamp_string)
 
-			// split contents into lines
-			lines := strings.Split(diffTimes, "\n")
+	// define function for replacing time stamps
+	replaceFunc := func(match string) string {
+		diff := datetimeFromString(strings.TrimSpace(match)).Sub(startDate)
+		return fmt.Sprintf(" %f", diff.Seconds())
+	}
 
-			//fmt.Println("split strings, about to to hits and additions")
+	// define regex for time stamp
+	date_regex, _ := regexp.Compile(` \d{13}`)
+	// replace times stamps
+	diffTimes := date_regex.ReplaceAllStringFunc(contents, replaceFunc)
 
-			// TODO get this to work with practice blocks
-			if levels != nil {
-				var enemyTargets int = int(math.Ceil(float64(levels.TargetNumber) / 2))
-				iterations := numIterations
-				//printHitAndAdditionTimes(lines, targets)
-				times := parseResults(lines, enemyTargets)
-				// fill with zeros if data not present
-				if len(times["addition"]) == 0 {
-					// times["addition"] = []float64{0., 0., 0., 0., 0., 0., 0., 0., 0., 0., 0., 0.}
-					times["addition"] = make([]float64, iterations)
-				}
-				if len(times["finalHit"]) == 0 {
-					// times["finalHit"] = []float64{0., 0., 0., 0., 0., 0., 0., 0., 0., 0., 0., 0.}
-					times["finalHit"] = make([]float64, iterations)
-				}
-				// Check lengths of arrays
-				for key, arr := range times {
-					if len(arr) != iterations {
-						panic(fmt.Sprintf("%s (possibly among others) does not have %d elements. it has %d", key, iterations, len(arr)))
-					}
-				}
+	// split contents into lines
+	return strings.Split(diffTimes, "\n"), nil
+}
 
-				/*if len(times["complete"]) != iterations || len(times["addition"]) != iterations || len(times["finalHit"]) != iterations ||
-					len(times["hits"]) != iterations || len(times["friendHits"]) != iterations || len(times["shots"]) != iterations ||
-					len(times["friendHovers"]) != iterations {
-					panic(fmt.Sprintf("one or more variables do not have %d elements %d in block %s", iterations, len(times["complete"]), block))
-				}*/
-
-				// TODO magic number 12 iterations should be looked up
-				for index := 0; index < iterations; index++ {
-					fmt.Fprintf(result_file, "%t, %d, %d, %v, %d, %f, %f, %f, %d, %d, %d, %d, %d, %d\n",
-						levels.Practice,
-						levels.TargetNumber, levels.TargetSpeed, levels.AdditionDifficulty, levels.TargetDifficulty,
-						times["addition"][index], times["finalHit"][index], times["complete"][index], int(times["hits"][index]),
-						int(times["friendHits"][index]), int(times["shots"][index]), int(times["friendHovers"][index]), int(times["op1"][index]), int(times["op2"][index]))
-				}
-			}
+// parse the measures of each iteration of a trial and check that there are the expected number of each
+func trialTimes(lines []string, levels *IVLevels, iterations int) (map[string][]float64, error) {
+	var enemyTargets int = int(math.Ceil(float64(levels.TargetNumber) / 2))
+	//printHitAndAdditionTimes(lines, targets)
+	times := parseResults(lines, enemyTargets)
+	// fill with zeros if data not present
+	if len(times["addition"]) == 0 {
+		times["addition"] = make([]float64, iterations)
+	}
+	if len(times["finalHit"]) == 0 {
+		times["finalHit"] = make([]float64, iterations)
+	}
+	// Check lengths of arrays
+	for key, arr := range times {
+		if len(arr) != iterations {
+			return nil, fmt.Errorf("%s (possibly among others) does not have %d elements. it has %d", key, iterations, len(arr))
 		}
 	}
+	return times, nil
+}
 
-	result_file.Close()
+func main() {
+	os.Exit(runCommand(os.Args[1:]))
 }
 func CountBucket(values []float64, min, max float64) int {
 	count := 0
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// the behavioural measures averaged over each block to correlate with workload
+var behaviourMeasures = []string{"complete", "concurrency", "accuracy"}
+
+// the workload variables of a block: its TLX scores, the model's utilization and the subject's behaviour
+type blockVariables struct {
+	Subject int
+	Block   string
+	Values  map[string]float64
+}
+
+// the variables of blocks in the order they were first seen, joined by subject and block
+type workloadJoin struct {
+	names  []string
+	seen   map[string]bool
+	blocks []*blockVariables
+	byKey  map[string]*blockVariables
+}
+
+func newWorkloadJoin() *workloadJoin {
+	return &workloadJoin{seen: make(map[string]bool), byKey: make(map[string]*blockVariables)}
+}
+
+func blockKey(subject int, block string) string {
+	return fmt.Sprint(subject, "/", block)
+}
+
+// set a variable of a block
+func (j *workloadJoin) set(subject int, block, name string, value float64) {
+	if !j.seen[name] {
+		j.seen[name] = true
+		j.names = append(j.names, name)
+	}
+	key := blockKey(subject, block)
+	b, ok := j.byKey[key]
+	if !ok {
+		b = &blockVariables{Subject: subject, Block: block, Values: make(map[string]float64)}
+		j.byKey[key] = b
+		j.blocks = append(j.blocks, b)
+	}
+	b.Values[name] = value
+}
+
+// join the TLX scores, whole block model utilization and mean behavioural measures of the non-practice blocks of
+// subjects. utilization variables are named by kind and unit, as subnetwork.cognitive. subjects whose surveys or
+// utilization cannot be read are reported and left out of those variables. if the observations were selected by a
+// filter, only the blocks with observations are joined. it fails only for unusable weights when fallback is fail
+func joinWorkload(layout *Layout, subjects []int, observations []Observation, fallback string, filtered bool) (*workloadJoin, error) {
+	kept := selectTask(observations, "all", nil)
+	selected := make(map[string]bool)
+	for _, o := range kept {
+		selected[blockKey(o.Subject, o.Block)] = true
+	}
+	use := func(subject int, block string) bool {
+		return !filtered || selected[blockKey(subject, block)]
+	}
+
+	join := newWorkloadJoin()
+	for _, subject := range subjects {
+		weights, weighting, err := chooseTLXWeights(layout, subject, fallback)
+		if err != nil {
+			return nil, fmt.Errorf("subject %d: %v", subject, err)
+		}
+		scores, _, err := subjectTLX(layout, subject, weights, weighting)
+		if err == nil {
+			for _, score := range scores {
+				if score.Levels.Practice || !use(subject, score.Block) {
+					continue
+				}
+				join.set(subject, score.Block, "raw", score.Raw)
+				join.set(subject, score.Block, "weighted", score.Weighted)
+				for index, scale := range tlxScales {
+					join.set(subject, score.Block, scale, score.Ratings[index])
+				}
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "leaving out the TLX of subject %d: %v\n", subject, err)
+		}
+
+		workloads, err := subjectWorkload(layout, subject, aggregateOptions{}, 0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "leaving out the utilization of subject %d: %v\n", subject, err)
+		}
+		for _, workload := range workloads {
+			if workload.Window >= 0 || workload.Levels.Practice || !use(subject, workload.Block) {
+				continue
+			}
+			for index, unit := range workload.Units {
+				join.set(subject, workload.Block, workload.Kind+"."+unit, workload.Utilization[index])
+			}
+		}
+	}
+
+	for _, group := range groupObservations(kept, []string{"subject", "block"}) {
+		first := kept[group.Members[0]]
+		for _, measure := range behaviourMeasures {
+			values := make([]float64, len(group.Members))
+			for i, index := range group.Members {
+				values[i] = kept[index].measure(measure)
+			}
+			m, _ := mean(values)
+			join.set(first.Subject, first.Block, measure, m)
+		}
+	}
+	return join, nil
+}
+
+// the correlation of two variables over blocks
+type variableCorrelation struct {
+	X, Y string
+	R, P float64
+	N    int
+}
+
+// the values of variables in blocks, a column for each variable with NaN for blocks without it
+func blockColumns(names []string, blocks []*blockVariables) [][]float64 {
+	columns := make([][]float64, len(names))
+	for index, name := range names {
+		columns[index] = make([]float64, len(blocks))
+		for row, b := range blocks {
+			value, ok := b.Values[name]
+			if !ok {
+				value = math.NaN()
+			}
+			columns[index][row] = value
+		}
+	}
+	return columns
+}
+
+// correlate each pair of variables over blocks, in the order of the names
+func correlateVariables(names []string, blocks []*blockVariables) []variableCorrelation {
+	columns := blockColumns(names, blocks)
+	correlations := make([]variableCorrelation, 0)
+	for i := range names {
+		for j := i + 1; j < len(names); j++ {
+			c := variableCorrelation{X: names[i], Y: names[j]}
+			c.R, c.P, c.N = correlation(columns[i], columns[j])
+			correlations = append(correlations, c)
+		}
+	}
+	return correlations
+}
+
+// call write with the blocks of each subject and then with all blocks pooled, as subject all
+func forBlocks(join *workloadJoin, subjects []int, write func(subject string, blocks []*blockVariables)) {
+	for _, subject := range subjects {
+		blocks := make([]*blockVariables, 0)
+		for _, b := range join.blocks {
+			if b.Subject == subject {
+				blocks = append(blocks, b)
+			}
+		}
+		write(strconv.Itoa(subject), blocks)
+	}
+	write("all", join.blocks)
+}
+
+// write the correlations of the blocks of each subject and then of all blocks pooled, a row for each pair
+func writeCorrelations(w io.Writer, join *workloadJoin, subjects []int) {
+	fmt.Fprintln(w, "subject, x, y, r, p, n")
+	forBlocks(join, subjects, func(subject string, blocks []*blockVariables) {
+		for _, c := range correlateVariables(join.names, blocks) {
+			fmt.Fprintf(w, "%s, %s, %s, %s, %s, %d\n", subject, c.X, c.Y, formatStat(c.R), formatStat(c.P), c.N)
+		}
+	})
+}
+
+// write the correlations of the blocks of each subject and then of all blocks pooled as an r and a p matrix, a row for
+// each variable. a variable correlates with itself by 1, or NA if it is constant, with a p value of NA
+func writeCorrelationMatrices(w io.Writer, join *workloadJoin, subjects []int) {
+	fmt.Fprintf(w, "subject, statistic, variable, %s\n", strings.Join(join.names, ", "))
+	forBlocks(join, subjects, func(subject string, blocks []*blockVariables) {
+		columns := blockColumns(join.names, blocks)
+		r := make([][]string, len(columns))
+		p := make([][]string, len(columns))
+		for i := range columns {
+			r[i] = make([]string, len(columns))
+			p[i] = make([]string, len(columns))
+			for j := range columns {
+				cr, cp, _ := correlation(columns[i], columns[j])
+				if i == j {
+					cp = math.NaN()
+				}
+				r[i][j], p[i][j] = formatStat(cr), formatStat(cp)
+			}
+		}
+		for _, matrix := range []struct {
+			statistic string
+			cells     [][]string
+		}{{"r", r}, {"p", p}} {
+			for i, name := range join.names {
+				fmt.Fprintf(w, "%s, %s, %s, %s\n", subject, matrix.statistic, name, strings.Join(matrix.cells[i], ", "))
+			}
+		}
+	})
+}
+
+func init() {
+	addCommand(&command{
+		name:  "correlate",
+		short: "correlate subjective workload with model utilization and behaviour",
+		long: `Correlate joins the variables of each block that is not a practice block, as analysis.R's
+compareWorkload put TLX scores next to the model's utilization: the raw and weighted TLX scores
+and each subscale as tlx scores them, the mean utilization of each module and subnetwork over the
+whole block as workload computes it, named like module.vision and subnetwork.cognitive, and the
+mean complete, concurrency and accuracy of the block's iterations.
+
+It lists the Pearson correlation of each pair of variables over the blocks of each subject and
+over the blocks of all subjects pooled, with subject all, with the two sided p value of r = 0
+and the number of blocks with both variables, a row for each pair. With -matrix it writes the
+same as a matrix of r and then of p for each subject and for all, a row for each variable.
+Correlations over fewer than 3 blocks, or with a variable that is the same in every block, are
+NA. The pooled correlations treat the blocks of different subjects as independent, so they mix
+the differences between subjects with the relation within them; the correlations of each
+subject show the latter alone.
+
+-where selects the iterations that are averaged, and with it only the blocks that have any of
+them are correlated, so that the TLX scores and utilization are of the same blocks. A filter on
+block variables such as oprange selects whole blocks; one on iteration variables such as
+complete keeps a block's TLX and utilization if any of its iterations pass. -invalid-weights is
+as for tlx. Unlike the other analyses only human trials are averaged unless -source says
+otherwise, as the TLX was answered by the subjects and a model run ingested into one of their
+blocks would be averaged with them.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			selection := addAnalysisSelectionFlags(flags)
+			caching := addCacheFlags(flags)
+			var fallback, where, source, out string
+			var matrix bool
+			flags.StringVar(&fallback, "invalid-weights", "na", "What to do for subjects with missing or invalid weights: na, unweighted or fail")
+			flags.StringVar(&where, "where", "", "Only use the iterations for which an expression is true")
+			flags.StringVar(&source, "source", "human", "Only use trials from this source, human or model, or all of them if empty. human by default, as the TLX scores are the subjects'")
+			flags.StringVar(&out, "out", "-", "The file to write, or - for stdout")
+			flags.BoolVar(&matrix, "matrix", false, "Write r and p matrices instead of a row for each pair")
+			return func(args []string) error {
+				if err := checkTLXFallback(fallback); err != nil {
+					return err
+				}
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				if where != "" {
+					if selection.options.where, err = parseFilter(where); err != nil {
+						return err
+					}
+				}
+				if selection.options.cache, err = caching.cache(layout); err != nil {
+					return err
+				}
+				observations, err := loadObservations(layout, selection.subjects(layout), source, selection.options)
+				if err != nil {
+					return err
+				}
+				// loadObservations has reported the excluded subjects
+				subjects := make([]int, 0)
+				for _, subject := range selection.subjects(layout) {
+					if info, ok := layout.registry[subject]; !ok || !info.Excluded {
+						subjects = append(subjects, subject)
+					}
+				}
+				join, err := joinWorkload(layout, subjects, observations, fallback, where != "")
+				if err != nil {
+					return err
+				}
+
+				w, err := layout.openOutput(out)
+				if err != nil {
+					return err
+				}
+				if matrix {
+					writeCorrelationMatrices(w, join, subjects)
+				} else {
+					writeCorrelations(w, join, subjects)
+				}
+				return w.Close()
+			}
+		},
+	})
+}
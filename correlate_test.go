@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestJoinWorkload(t *testing.T) {
+	layout := tlxLayout(t)
+	practice := &IVLevels{Practice: true, AdditionDifficulty: []int{13, 25}}
+	dual := &IVLevels{TargetNumber: 1, TargetSpeed: 200, AdditionDifficulty: []int{1, 12}, TargetDifficulty: 1}
+	writeTestFile(t, filepath.Join(layout.SubjectDir(1), "weights.txt"),
+		`weights: {"Mental Demand": 5, "Physical Demand": 0, "Temporal Demand": 4, "Performance": 2, "Effort": 3, "Frustration": 1}`)
+	for block, survey := range map[string]string{
+		"block0": `{"mental": 10, "physical": 10, "temporal": 10, "performance": 10, "effort": 10, "frustration": 10}`,
+		"block1": `{"mental": 80, "physical": 50, "temporal": 50, "performance": 50, "effort": 50, "frustration": 50}`,
+		"block2": `{"mental": 40, "physical": 40, "temporal": 40, "performance": 40, "effort": 40, "frustration": 40}`,
+	} {
+		levels := `{"trials": 1, "practice": false, "targetNumber": 1, "targetSpeed": 200, "additionDifficulty": [1, 12], "targetDifficulty": 1}`
+		if block == "block0" {
+			levels = `{"trials": 1, "practice": true, "targetNumber": 0, "targetSpeed": 0, "additionDifficulty": [13, 25], "targetDifficulty": 0}`
+		}
+		writeTestFile(t, filepath.Join(layout.BlockDir(1, block), "block.txt"), levels)
+		writeTestFile(t, filepath.Join(layout.BlockDir(1, block), "survey.txt"), "survey: "+survey)
+	}
+	writeTestFile(t, filepath.Join(layout.BlockDir(1, "block1"), "subnetwork.txt"), "clock,perceptual,cognitive,motor\n0,0.5,0.25,0\n1,0.7,0.75,0.5\n")
+
+	observation := func(block string, levels *IVLevels, complete, accuracy, concurrency float64) Observation {
+		return Observation{Iteration: Iteration{Subject: 1, Block: block, Levels: levels, Complete: complete},
+			Type: "main", Accuracy: accuracy, Concurrency: concurrency}
+	}
+	observations := []Observation{
+		observation("block0", practice, 9, 0, 0),
+		observation("block1", dual, 3, 0.5, 0.25),
+		observation("block1", dual, 5, 1, 0.75),
+		observation("block2", dual, 6, 1, math.NaN()),
+	}
+	join, err := joinWorkload(layout, []int{1}, observations, "fail", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"raw", "weighted", "mental", "physical", "temporal", "performance", "effort", "frustration",
+		"subnetwork.perceptual", "subnetwork.cognitive", "subnetwork.motor", "complete", "concurrency", "accuracy"}
+	if !reflect.DeepEqual(join.names, names) {
+		t.Errorf("joined %v, expected %v", join.names, names)
+	}
+	// the practice block is left out
+	if len(join.blocks) != 2 || join.blocks[0].Block != "block1" || join.blocks[1].Block != "block2" {
+		t.Fatalf("joined the blocks %v, expected block1 and block2", join.blocks)
+	}
+	// the weighted score of block1 is (80*5 + 50*10) / 15
+	want := map[string]float64{"raw": 330, "weighted": 60, "mental": 80, "frustration": 50, "subnetwork.perceptual": 0.6,
+		"subnetwork.cognitive": 0.5, "subnetwork.motor": 0.25, "complete": 4, "concurrency": 0.5, "accuracy": 0.75}
+	for name, value := range want {
+		checkClose(t, "block1 "+name, join.blocks[0].Values[name], value, 1e-12)
+	}
+	// block2 has no utilization and only a NaN concurrency
+	if _, ok := join.blocks[1].Values["subnetwork.motor"]; ok || !math.IsNaN(join.blocks[1].Values["concurrency"]) || join.blocks[1].Values["raw"] != 240 {
+		t.Errorf("block2 has %v", join.blocks[1].Values)
+	}
+
+	// a filter keeps the TLX and utilization of the blocks it kept iterations of
+	join, err = joinWorkload(layout, []int{1}, observations[:3], "fail", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(join.blocks) != 1 || join.blocks[0].Block != "block1" {
+		t.Errorf("the filtered join has the blocks %v, expected block1", join.blocks)
+	}
+	join, err = joinWorkload(layout, []int{1}, observations[:3], "fail", false)
+	if err != nil || len(join.blocks) != 2 {
+		t.Errorf("without a filter the TLX of block2 was left out")
+	}
+}
+
+func TestWriteCorrelationMatrices(t *testing.T) {
+	join := newWorkloadJoin()
+	for index, x := range []float64{1, 2, 3, 4} {
+		block := fmt.Sprint("b", index+1)
+		join.set(1, block, "x", x)
+		join.set(1, block, "y", 2*x)
+		join.set(1, block, "z", 1)
+	}
+	for index, x := range []float64{1, 2, 3} {
+		block := fmt.Sprint("b", index+1)
+		join.set(2, block, "x", x)
+		join.set(2, block, "y", 4-x)
+	}
+	var buffer bytes.Buffer
+	writeCorrelationMatrices(&buffer, join, []int{1, 2})
+	// z is constant in subject 1 and missing in subject 2, and pooled the deviations of x and y give
+	// sxy = 74/7, sxx = 52/7 and syy = 262/7, so r = 74 / sqrt(52 * 262) = 0.633986 with t = 1.8327 on 5 df
+	want := `subject, statistic, variable, x, y, z
+1, r, x, 1.000000, 1.000000, NA
+1, r, y, 1.000000, 1.000000, NA
+1, r, z, NA, NA, NA
+1, p, x, NA, 0.000000, NA
+1, p, y, 0.000000, NA, NA
+1, p, z, NA, NA, NA
+2, r, x, 1.000000, -1.000000, NA
+2, r, y, -1.000000, 1.000000, NA
+2, r, z, NA, NA, NA
+2, p, x, NA, 0.000000, NA
+2, p, y, 0.000000, NA, NA
+2, p, z, NA, NA, NA
+all, r, x, 1.000000, 0.633986, NA
+all, r, y, 0.633986, 1.000000, NA
+all, r, z, NA, NA, NA
+all, p, x, NA, 0.126260, NA
+all, p, y, 0.126260, NA, NA
+all, p, z, NA, NA, NA
+`
+	if buffer.String() != want {
+		t.Errorf("wrote\n%s\nexpected\n%s", buffer.String(), want)
+	}
+
+	buffer.Reset()
+	writeCorrelations(&buffer, join, []int{2})
+	want = `subject, x, y, r, p, n
+2, x, y, -1.000000, 0.000000, 3
+2, x, z, NA, NA, 0
+2, y, z, NA, NA, 0
+all, x, y, 0.633986, 0.126260, 7
+all, x, z, NA, NA, 4
+all, y, z, NA, NA, 4
+`
+	if buffer.String() != want {
+		t.Errorf("wrote\n%s\nexpected\n%s", buffer.String(), want)
+	}
+}
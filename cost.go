@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+)
+
+// the proportional slowdown of a task in a dual task condition relative to the task alone, with a bootstrap
+// confidence interval
+type dualTaskCost struct {
+	Subject      int
+	Source       string
+	Condition    condition
+	Task         string
+	SingleN      int
+	Single       float64
+	DualN        int
+	Dual         float64
+	Cost         float64
+	Lower, Upper float64
+}
+
+// the cost of doing a task in a dual task condition: how much longer it took on average than alone, as a fraction
+// of the time alone. it is NaN if the time alone is zero
+func taskCost(single, dual []float64) float64 {
+	s, _ := mean(single)
+	d, _ := mean(dual)
+	if s == 0 {
+		return math.NaN()
+	}
+	return (d - s) / s
+}
+
+// a percentile bootstrap interval of the cost, resampling the single and dual task times separately
+func bootstrapCost(single, dual []float64, samples int, confidence float64, random *rand.Rand) (float64, float64) {
+	resample := func(values, into []float64) {
+		for index := range into {
+			into[index] = values[random.Intn(len(values))]
+		}
+	}
+	s, d := make([]float64, len(single)), make([]float64, len(dual))
+	costs := make([]float64, samples)
+	for index := range costs {
+		resample(single, s)
+		resample(dual, d)
+		costs[index] = taskCost(s, d)
+	}
+	sorted := sortedValues(costs)
+	return quantile(sorted, (1-confidence)/2), quantile(sorted, (1+confidence)/2)
+}
+
+// the addition and targeting costs of each subject and source under each dual task condition. the addition time
+// of a dual task block is compared with the addition block of the same operand range, and its target time with the
+// targeting block of the same speed and difficulty, as computeConcurrency matches them. practice is left out
+func dualTaskCosts(observations []Observation, samples int, confidence float64, seed int64) []dualTaskCost {
+	singles := make(map[singleTaskKey][]float64)
+	for _, o := range observations {
+		if o.Levels.Practice {
+			continue
+		}
+		switch o.Type {
+		case "addition":
+			key := singleTaskKey{o.Subject, o.Source, "addition", opRangeLevel(o.Levels)}
+			singles[key] = append(singles[key], o.Addition)
+		case "targeting":
+			key := singleTaskKey{o.Subject, o.Source, "targeting", fmt.Sprint(o.Levels.TargetSpeed, o.Levels.TargetDifficulty)}
+			singles[key] = append(singles[key], o.Target)
+		}
+	}
+
+	random := rand.New(rand.NewSource(seed))
+	costs := make([]dualTaskCost, 0)
+	dual := selectTask(observations, "main", nil)
+	for _, group := range groupObservations(dual, []string{"subject", "source", "speed", "difficulty", "oprange"}) {
+		first := dual[group.Members[0]]
+		tasks := []struct {
+			name  string
+			key   singleTaskKey
+			value func(Observation) float64
+		}{
+			{"addition", singleTaskKey{first.Subject, first.Source, "addition", opRangeLevel(first.Levels)},
+				func(o Observation) float64 { return o.Addition }},
+			{"targeting", singleTaskKey{first.Subject, first.Source, "targeting", fmt.Sprint(first.Levels.TargetSpeed, first.Levels.TargetDifficulty)},
+				func(o Observation) float64 { return o.Target }},
+		}
+		for _, task := range tasks {
+			single := sortedValues(singles[task.key])
+			values := make([]float64, len(group.Members))
+			for i, index := range group.Members {
+				values[i] = task.value(dual[index])
+			}
+			values = sortedValues(values)
+			if len(single) == 0 || len(values) == 0 {
+				fmt.Fprintf(os.Stderr, "subject %d has no %s times to compare for %s, %s, %s\n", first.Subject, task.name,
+					group.Levels[2], group.Levels[3], group.Levels[4])
+				continue
+			}
+			c := dualTaskCost{Subject: first.Subject, Source: first.Source, Condition: observationCondition(first), Task: task.name,
+				SingleN: len(single), DualN: len(values), Cost: taskCost(single, values)}
+			c.Single, _ = mean(single)
+			c.Dual, _ = mean(values)
+			c.Lower, c.Upper = bootstrapCost(single, values, samples, confidence, random)
+			costs = append(costs, c)
+		}
+	}
+	return costs
+}
+
+func writeCosts(w io.Writer, costs []dualTaskCost) {
+	fmt.Fprintln(w, "subject, source, speed, difficulty, oprange, task, singleN, single, dualN, dual, cost, lower, upper")
+	for _, c := range costs {
+		fmt.Fprintf(w, "%d, %s, %s, %s, %s, %s, %d, %s, %d, %s, %s, %s, %s\n", c.Subject, c.Source, c.Condition.Speed,
+			c.Condition.Difficulty, c.Condition.OpRange, c.Task, c.SingleN, formatStat(c.Single), c.DualN, formatStat(c.Dual),
+			formatStat(c.Cost), formatStat(c.Lower), formatStat(c.Upper))
+	}
+}
+
+func init() {
+	addCommand(&command{
+		name:  "cost",
+		short: "compute the dual-task cost of addition and targeting",
+		long: `Cost compares the time each task took in the dual task blocks with the time it took alone.
+For each subject and each dual task condition it writes the mean addition time of the condition
+and of the addition blocks with the same operand range, and the mean target time and that of
+the targeting blocks with the same speed and difficulty, with the cost of each: the proportional
+slowdown (dual - single) / single.
+
+The lower and upper ends of the -ci confidence interval of each cost are percentiles of -boot
+bootstrap costs, each from the single and dual task times resampled separately with
+replacement. -seed makes the intervals reproducible. Practice blocks are left out.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			selection := addAnalysisSelectionFlags(flags)
+			caching := addCacheFlags(flags)
+			var where, source, out string
+			var samples int
+			var seed int64
+			var confidence float64
+			flags.IntVar(&samples, "boot", 2000, "The number of bootstrap samples")
+			flags.Float64Var(&confidence, "ci", 0.95, "The confidence level of the intervals")
+			flags.Int64Var(&seed, "seed", 1, "The seed of the bootstrap's random numbers")
+			flags.StringVar(&where, "where", "", "Only use the iterations for which an expression is true")
+			flags.StringVar(&source, "source", "", "Only use trials from this source, human or model")
+			flags.StringVar(&out, "out", "-", "The file to write, or - for stdout")
+			return func(args []string) error {
+				if samples < 1 {
+					return fmt.Errorf("-boot must be positive")
+				}
+				if confidence <= 0 || confidence >= 1 {
+					return fmt.Errorf("-ci must be between 0 and 1")
+				}
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				if where != "" {
+					if selection.options.where, err = parseFilter(where); err != nil {
+						return err
+					}
+				}
+				if selection.options.cache, err = caching.cache(layout); err != nil {
+					return err
+				}
+				observations, err := loadObservations(layout, selection.subjects(layout), source, selection.options)
+				if err != nil {
+					return err
+				}
+
+				w, err := layout.openOutput(out)
+				if err != nil {
+					return err
+				}
+				writeCosts(w, dualTaskCosts(observations, samples, confidence, seed))
+				return w.Close()
+			}
+		},
+	})
+}
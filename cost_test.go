@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var (
+	costSingle = []float64{2.1, 2.9, 3.4, 2.6, 3.0}
+	costDual   = []float64{4.2, 3.9, 5.1, 4.4, 4.8, 4.0}
+)
+
+func TestTaskCost(t *testing.T) {
+	// the means are 14 / 5 = 2.8 alone and 26.4 / 6 = 4.4 together, so the cost is 1.6 / 2.8 = 4 / 7
+	checkClose(t, "cost", taskCost(costSingle, costDual), 4.0/7, 1e-12)
+	if cost := taskCost([]float64{0, 0}, costDual); !math.IsNaN(cost) {
+		t.Errorf("a zero single task time gives a cost of %g, expected NaN", cost)
+	}
+	if formatStat(taskCost([]float64{0, 0}, costDual)) != "NA" {
+		t.Errorf("a cost without a single task time is not written as NA")
+	}
+}
+
+func TestBootstrapCost(t *testing.T) {
+	lower, upper := bootstrapCost(costSingle, costDual, 2000, 0.95, rand.New(rand.NewSource(1)))
+	// the interval for seed 1, which must not change between runs or versions
+	checkClose(t, "lower", lower, 0.36165577342047939, 1e-12)
+	checkClose(t, "upper", upper, 0.89077575474634296, 1e-12)
+	if cost := taskCost(costSingle, costDual); lower > cost || upper < cost {
+		t.Errorf("the interval %g to %g does not contain the cost %g", lower, upper, cost)
+	}
+	again, _ := bootstrapCost(costSingle, costDual, 2000, 0.95, rand.New(rand.NewSource(1)))
+	if again != lower {
+		t.Errorf("the same seed gave %g and %g", lower, again)
+	}
+
+	// without variation every resample has the same cost
+	lower, upper = bootstrapCost([]float64{2, 2}, []float64{3, 3, 3}, 100, 0.95, rand.New(rand.NewSource(1)))
+	if lower != 0.5 || upper != 0.5 {
+		t.Errorf("constant times give an interval of %g to %g, expected 0.5", lower, upper)
+	}
+	lower, upper = bootstrapCost([]float64{0, 0}, []float64{3, 3, 3}, 100, 0.95, rand.New(rand.NewSource(1)))
+	if !math.IsNaN(lower) || !math.IsNaN(upper) {
+		t.Errorf("zero single task times give an interval of %g to %g, expected NaN", lower, upper)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// the types of values in a filter expression
+type exprKind int
+
+const (
+	numberKind exprKind = iota
+	stringKind
+	boolKind
+)
+
+func (k exprKind) String() string {
+	return [...]string{"number", "string", "bool"}[k]
+}
+
+// a node of a parsed filter expression. values are float64, string or bool
+type expr interface {
+	// find the type of the expression given the types of the variables
+	check(variables map[string]exprKind) (exprKind, error)
+	// evaluate a checked expression given the values of the variables
+	eval(lookup func(name string) interface{}) interface{}
+	// add the names of the variables used by the expression to a set
+	names(set map[string]bool)
+}
+
+type literalExpr struct {
+	value interface{}
+}
+
+func (e literalExpr) check(variables map[string]exprKind) (exprKind, error) {
+	switch e.value.(type) {
+	case float64:
+		return numberKind, nil
+	case string:
+		return stringKind, nil
+	}
+	return boolKind, nil
+}
+func (e literalExpr) eval(lookup func(name string) interface{}) interface{} { return e.value }
+func (e literalExpr) names(set map[string]bool)                             {}
+
+type variableExpr struct {
+	name string
+}
+
+func (e variableExpr) check(variables map[string]exprKind) (exprKind, error) {
+	if kind, ok := variables[e.name]; ok {
+		return kind, nil
+	}
+	return 0, fmt.Errorf("unknown variable %q", e.name)
+}
+func (e variableExpr) eval(lookup func(name string) interface{}) interface{} { return lookup(e.name) }
+func (e variableExpr) names(set map[string]bool)                             { set[e.name] = true }
+
+type notExpr struct {
+	operand expr
+}
+
+func (e notExpr) check(variables map[string]exprKind) (exprKind, error) {
+	kind, err := e.operand.check(variables)
+	if err != nil {
+		return 0, err
+	}
+	if kind != boolKind {
+		return 0, fmt.Errorf("! needs a bool, not a %s", kind)
+	}
+	return boolKind, nil
+}
+func (e notExpr) eval(lookup func(name string) interface{}) interface{} {
+	return !e.operand.eval(lookup).(bool)
+}
+func (e notExpr) names(set map[string]bool) { e.operand.names(set) }
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e binaryExpr) check(variables map[string]exprKind) (exprKind, error) {
+	left, err := e.left.check(variables)
+	if err != nil {
+		return 0, err
+	}
+	right, err := e.right.check(variables)
+	if err != nil {
+		return 0, err
+	}
+	switch e.op {
+	case "&&", "||":
+		if left != boolKind || right != boolKind {
+			return 0, fmt.Errorf("%s needs bools, not a %s and a %s", e.op, left, right)
+		}
+	case "==", "!=":
+		if left != right {
+			return 0, fmt.Errorf("cannot compare a %s with a %s", left, right)
+		}
+	default:
+		if left != right || left == boolKind {
+			return 0, fmt.Errorf("%s needs two numbers or two strings, not a %s and a %s", e.op, left, right)
+		}
+	}
+	return boolKind, nil
+}
+
+func (e binaryExpr) eval(lookup func(name string) interface{}) interface{} {
+	switch e.op {
+	case "&&":
+		return e.left.eval(lookup).(bool) && e.right.eval(lookup).(bool)
+	case "||":
+		return e.left.eval(lookup).(bool) || e.right.eval(lookup).(bool)
+	case "==":
+		return e.left.eval(lookup) == e.right.eval(lookup)
+	case "!=":
+		return e.left.eval(lookup) != e.right.eval(lookup)
+	}
+	// ordered comparison of two numbers or two strings
+	var cmp int
+	switch left := e.left.eval(lookup).(type) {
+	case float64:
+		right := e.right.eval(lookup).(float64)
+		if left < right {
+			cmp = -1
+		} else if left > right {
+			cmp = 1
+		}
+	case string:
+		cmp = strings.Compare(left, e.right.eval(lookup).(string))
+	}
+	switch e.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+func (e binaryExpr) names(set map[string]bool) {
+	e.left.names(set)
+	e.right.names(set)
+}
+
+// a token of a filter expression
+type exprToken struct {
+	// "number", "string", "ident", "op" or "end"
+	kind string
+	text string
+	pos  int
+}
+
+// split a filter expression into tokens
+func tokenizeExpr(source string) ([]exprToken, error) {
+	tokens := make([]exprToken, 0)
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, exprToken{"ident", string(runes[start:i]), start})
+		case unicode.IsDigit(r) || r == '.' || r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, exprToken{"number", string(runes[start:i]), start})
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != r {
+				i++
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			tokens = append(tokens, exprToken{"string", string(runes[start+1 : i]), start})
+			i++
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", r, i)
+			}
+			tokens = append(tokens, exprToken{"op", op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{"end", "", len(runes)}), nil
+}
+
+// a recursive descent parser over the tokens of a filter expression
+type exprParser struct {
+	tokens []exprToken
+	next   int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.next]
+}
+
+// consume the next token if it is the given operator
+func (p *exprParser) accept(op string) bool {
+	if token := p.peek(); token.kind == "op" && token.text == op {
+		p.next++
+		return true
+	}
+	return false
+}
+
+// or := and ("||" and)*
+func (p *exprParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right expr
+		if right, err = p.parseAnd(); err == nil {
+			left = binaryExpr{"||", left, right}
+		}
+	}
+	return left, err
+}
+
+// and := comparison ("&&" comparison)*
+func (p *exprParser) parseAnd() (expr, error) {
+	left, err := p.parseComparison()
+	for err == nil && p.accept("&&") {
+		var right expr
+		if right, err = p.parseComparison(); err == nil {
+			left = binaryExpr{"&&", left, right}
+		}
+	}
+	return left, err
+}
+
+// comparison := unary (("==" | "!=" | "<" | "<=" | ">" | ">=") unary)?
+func (p *exprParser) parseComparison() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return binaryExpr{op, left, right}, nil
+		}
+	}
+	return left, nil
+}
+
+// unary := "!" unary | "(" or ")" | number | string | true | false | identifier
+func (p *exprParser) parseUnary() (expr, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		return notExpr{operand}, err
+	}
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("expected ) at %d", p.peek().pos)
+		}
+		return inner, nil
+	}
+	token := p.peek()
+	p.next++
+	switch token.kind {
+	case "number":
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", token.text, token.pos)
+		}
+		return literalExpr{number}, nil
+	case "string":
+		return literalExpr{token.text}, nil
+	case "ident":
+		if token.text == "true" || token.text == "false" {
+			return literalExpr{token.text == "true"}, nil
+		}
+		return variableExpr{token.text}, nil
+	case "end":
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", token.text, token.pos)
+}
+
+// parse a filter expression
+func parseExpr(source string) (expr, error) {
+	tokens, err := tokenizeExpr(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &exprParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token.kind != "end" {
+		return nil, fmt.Errorf("unexpected %q at %d", token.text, token.pos)
+	}
+	return root, nil
+}
+
+// filter selects blocks and iterations with an expression over the variables of an iteration
+type filter struct {
+	source string
+	root   expr
+	// set if the expression only uses variables known before a trial is parsed
+	blockLevel bool
+}
+
+// parse and check a filter expression
+func parseFilter(source string) (*filter, error) {
+	root, err := parseExpr(source)
+	if err != nil {
+		return nil, fmt.Errorf("bad filter %q: %v", source, err)
+	}
+	kinds := make(map[string]exprKind, len(iterationVariables))
+	for name, variable := range iterationVariables {
+		kinds[name] = variable.kind
+	}
+	if kind, err := root.check(kinds); err != nil {
+		return nil, fmt.Errorf("bad filter %q: %v", source, err)
+	} else if kind != boolKind {
+		return nil, fmt.Errorf("bad filter %q: the expression is a %s, not a bool", source, kind)
+	}
+	names := make(map[string]bool)
+	root.names(names)
+	blockLevel := true
+	for name := range names {
+		blockLevel = blockLevel && iterationVariables[name].block
+	}
+	return &filter{source, root, blockLevel}, nil
+}
+
+// test if a block could contain iterations that pass the filter
+func (f *filter) matchBlock(subject int, block string, levels *IVLevels) bool {
+	if !f.blockLevel {
+		return true
+	}
+	return f.matchIteration(Iteration{Subject: subject, Block: block, Levels: levels})
+}
+
+// test if an iteration passes the filter
+func (f *filter) matchIteration(it Iteration) bool {
+	return f.root.eval(it.field).(bool)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	kinds := map[string]exprKind{"x": numberKind, "s": stringKind, "b": boolKind}
+	values := map[string]interface{}{"x": 3.0, "s": "high", "b": true}
+	lookup := func(name string) interface{} { return values[name] }
+	for _, test := range []struct {
+		source string
+		want   bool
+	}{
+		// && binds tighter than ||, whichever comes first
+		{"true || false && false", true},
+		{"false && true || true", true},
+		{"(true || false) && false", false},
+		// ! binds tighter than && and applies to a comparison only in parentheses
+		{"!false && false", false},
+		{"!(false && false)", true},
+		{"!!b", true},
+		{"!(x == 3)", false},
+		// numbers compare by value and strings in byte order
+		{"10 < 9", false},
+		{"'10' < '9'", true},
+		{"x >= 3 && x <= 3 && x != 4", true},
+		{"-1 < 0 && -0.5 > -1", true},
+		{`s == "high" && s > 'h' && "low" != s`, true},
+		{"s < 'a'", false},
+		{"b == true && b != false", true},
+	} {
+		root, err := parseExpr(test.source)
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+		if kind, err := root.check(kinds); err != nil || kind != boolKind {
+			t.Errorf("%s: checked as a %s, %v", test.source, kind, err)
+			continue
+		}
+		if got := root.eval(lookup).(bool); got != test.want {
+			t.Errorf("%s is %v, expected %v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	high := &IVLevels{TargetSpeed: 200, AdditionDifficulty: []int{13, 25}}
+	low := &IVLevels{TargetSpeed: 200, AdditionDifficulty: []int{1, 12}}
+	f, err := parseFilter(`oprange=="high"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.blockLevel {
+		t.Errorf("oprange is known before a trial is parsed")
+	}
+	if !f.matchBlock(1, "b", high) || f.matchBlock(1, "b", low) {
+		t.Errorf(`oprange=="high" does not select the blocks with the high range only`)
+	}
+
+	f, err = parseFilter("speed == 200 && complete > 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.blockLevel || !f.matchBlock(1, "b", low) {
+		t.Errorf("a filter on complete selects blocks before their iterations are known")
+	}
+	if !f.matchIteration(Iteration{Levels: low, Complete: 2.5}) || f.matchIteration(Iteration{Levels: low, Complete: 1.5}) {
+		t.Errorf("speed == 200 && complete > 2 does not select by complete")
+	}
+
+	for _, test := range []struct {
+		source, err string
+	}{
+		{"speeed == 200", `unknown variable "speeed"`},
+		{`oprange == 1`, "cannot compare a string with a number"},
+		{`speed < "fast"`, "< needs two numbers or two strings"},
+		{"practice < true", "< needs two numbers or two strings"},
+		{"!speed", "! needs a bool"},
+		{"speed && practice", "&& needs bools"},
+		{"speed", "the expression is a number, not a bool"},
+		{"(speed == 200", "expected )"},
+		{"speed ==", "unexpected end of expression"},
+		{"speed == 200 200", `unexpected "200"`},
+		{`block == "a`, "unterminated string"},
+		{"speed = 200", "unexpected '='"},
+		{"speed == 1.2.3", `bad number "1.2.3"`},
+	} {
+		_, err := parseFilter(test.source)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, expected %s", test.source, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// the quantiles in the summary of each histogram
+var histQuantiles = []float64{0, 0.05, 0.25, 0.5, 0.75, 0.95, 1}
+
+// the most bins a histogram may have, so that a -width far too small for the values is an error rather than
+// millions of rows
+const maxHistBins = 1000
+
+// a bin width of the range over Sturges' number of bins, rounded up to 1, 2 or 5 times a power of ten
+func niceWidth(sorted []float64) float64 {
+	span := sorted[len(sorted)-1] - sorted[0]
+	if span == 0 {
+		return 1
+	}
+	bins := math.Ceil(math.Log2(float64(len(sorted)))) + 1
+	raw := span / bins
+	power := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, step := range []float64{1, 2, 5} {
+		if raw <= step*power {
+			return step * power
+		}
+	}
+	return 10 * power
+}
+
+// write a histogram of values as rows of x's, one for each bin of a width from a multiple of it below the smallest
+// value, scaled so the longest bar has at most barWidth x's, after a summary of the values. it fails if the width
+// would give more than maxHistBins bins
+func writeHistogram(w io.Writer, values []float64, width float64, barWidth int) error {
+	sorted := sortedValues(values)
+	m, n := mean(sorted)
+	names := []string{"n", "mean", "sd"}
+	stats := []string{strconv.Itoa(n), formatStat(m), formatStat(sd(sorted))}
+	for _, p := range histQuantiles {
+		names = append(names, "q"+strconv.FormatFloat(p*100, 'f', -1, 64))
+		stats = append(stats, formatStat(quantile(sorted, p)))
+	}
+	fmt.Fprintln(w, strings.Join(names, ", "))
+	fmt.Fprintln(w, strings.Join(stats, ", "))
+	if n == 0 {
+		return nil
+	}
+	if width <= 0 {
+		width = niceWidth(sorted)
+	}
+
+	start := math.Floor(sorted[0]/width) * width
+	bins := math.Floor((sorted[n-1]-start)/width) + 1
+	if bins > maxHistBins {
+		return fmt.Errorf("a bin width of %g gives %.0f bins from %g to %g, more than %d", width, bins, sorted[0], sorted[n-1], maxHistBins)
+	}
+	counts := make([]int, int(bins))
+	for _, value := range sorted {
+		// rounding can put a value just outside the first or last bin
+		bin := int(math.Floor((value - start) / width))
+		if bin < 0 {
+			bin = 0
+		} else if bin >= len(counts) {
+			bin = len(counts) - 1
+		}
+		counts[bin]++
+	}
+	most := 0
+	for _, count := range counts {
+		if count > most {
+			most = count
+		}
+	}
+	scale := 1.0
+	if most > barWidth {
+		scale = float64(barWidth) / float64(most)
+	}
+	for bin, count := range counts {
+		bar := strings.Repeat("x", int(math.Ceil(float64(count)*scale)))
+		fmt.Fprintf(w, "%10.3f | %s %d\n", start+float64(bin)*width, bar, count)
+	}
+	return nil
+}
+
+func init() {
+	addCommand(&command{
+		name:  "hist",
+		short: "print histograms and quantiles of a measure",
+		long: `Hist prints the number of values, mean, standard deviation and quantiles of a measure and a
+histogram of it, to check a subject's data right after a session. Each row of the histogram is a
+bin from the value at its start up to the next, with a bar of x's and the count. -width sets the
+width of the bins; without it the width is about the range over Sturges' number of bins, rounded
+to 1, 2 or 5 times a power of ten. A -width that would give more than 1000 bins is an error.
+Bars are scaled down to at most -bar-width x's.
+
+With -by there is a histogram for each combination of the levels of the factors, e.g. -by
+type,oprange, headed by the factors and levels. The factors are those of summary. Practice
+blocks are left out unless -practice is given.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			selection := addAnalysisSelectionFlags(flags)
+			caching := addCacheFlags(flags)
+			var measure, byList, where, source, out string
+			var width float64
+			var barWidth int
+			var practice bool
+			flags.StringVar(&measure, "measure", "complete", "The measure to plot")
+			flags.Float64Var(&width, "width", 0, "The width of the bins, or 0 to choose one")
+			flags.IntVar(&barWidth, "bar-width", 60, "The length of the longest bar")
+			flags.StringVar(&byList, "by", "", "The factors to print a histogram for each level of, separated by commas")
+			flags.BoolVar(&practice, "practice", false, "Include practice blocks")
+			flags.StringVar(&where, "where", "", "Only use the iterations for which an expression is true")
+			flags.StringVar(&source, "source", "", "Only use trials from this source, human or model")
+			flags.StringVar(&out, "out", "-", "The file to write, or - for stdout")
+			return func(args []string) error {
+				if !isMeasure(measure) {
+					return fmt.Errorf("unknown measure %q", measure)
+				}
+				if width < 0 || barWidth < 1 {
+					return fmt.Errorf("-width must not be negative and -bar-width must be positive")
+				}
+				by, err := parseNameList(byList, isFactor, "factor")
+				if err != nil {
+					return err
+				}
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				if where != "" {
+					if selection.options.where, err = parseFilter(where); err != nil {
+						return err
+					}
+				}
+				if selection.options.cache, err = caching.cache(layout); err != nil {
+					return err
+				}
+				observations, err := loadObservations(layout, selection.subjects(layout), source, selection.options)
+				if err != nil {
+					return err
+				}
+				if !practice {
+					observations = selectTask(observations, "all", nil)
+				}
+
+				w, err := layout.openOutput(out)
+				if err != nil {
+					return err
+				}
+				for index, group := range groupObservations(observations, by) {
+					if index > 0 {
+						fmt.Fprintln(w)
+					}
+					heading := []string{measure}
+					for f, factor := range by {
+						heading = append(heading, factor+" "+group.Levels[f])
+					}
+					fmt.Fprintln(w, strings.Join(heading, ", "))
+					values := make([]float64, len(group.Members))
+					for i, member := range group.Members {
+						values[i] = observations[member].measure(measure)
+					}
+					if err := writeHistogram(w, values, width, barWidth); err != nil {
+						w.Close()
+						return err
+					}
+				}
+				return w.Close()
+			}
+		},
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestNiceWidth(t *testing.T) {
+	for _, test := range []struct {
+		sorted []float64
+		width  float64
+	}{
+		// 2 values have 2 bins, so a span of 100 has a raw width of 50
+		{[]float64{0, 100}, 50},
+		// 8 values have 4 bins, 1.75 is rounded up to 2
+		{[]float64{0, 1, 2, 3, 4, 5, 6, 7}, 2},
+		// 4 values have 3 bins, 0.37 / 3 is rounded up to 0.2
+		{[]float64{0, 0.1, 0.2, 0.37}, 0.2},
+		// 9.5 is rounded up to the next power of ten
+		{[]float64{1, 20}, 10},
+		{[]float64{4, 4, 4}, 1},
+	} {
+		checkClose(t, "width", niceWidth(test.sorted), test.width, 1e-12)
+	}
+}
+
+func TestWriteHistogram(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := writeHistogram(&buffer, []float64{3.5, 1, 7, math.NaN(), 2.5, 3}, 2, 2); err != nil {
+		t.Fatal(err)
+	}
+	// the mean of 1, 2.5, 3, 3.5 and 7 is 3.4 with squared deviations summing to 19.7, and the quantiles interpolate
+	// as R's type 7. the most in a bin is 3, so bars are scaled by 2 / 3 and rounded up
+	want := `n, mean, sd, q0, q5, q25, q50, q75, q95, q100
+5, 3.400000, 2.219234, 1.000000, 1.300000, 2.500000, 3.000000, 3.500000, 6.300000, 7.000000
+     0.000 | x 1
+     2.000 | xx 3
+     4.000 |  0
+     6.000 | x 1
+`
+	if buffer.String() != want {
+		t.Errorf("wrote\n%s\nexpected\n%s", buffer.String(), want)
+	}
+
+	buffer.Reset()
+	if err := writeHistogram(&buffer, []float64{math.NaN()}, 0, 10); err != nil || strings.Count(buffer.String(), "\n") != 2 {
+		t.Errorf("without values wrote %q and %v, expected only the summary", buffer.String(), err)
+	}
+	// the last value is in a bin of its own at the end
+	buffer.Reset()
+	if err := writeHistogram(&buffer, []float64{0, 999}, 1, 10); err != nil || strings.Count(buffer.String(), "\n") != 1002 {
+		t.Errorf("1000 bins wrote %d lines and %v", strings.Count(buffer.String(), "\n"), err)
+	}
+	if err := writeHistogram(&buffer, []float64{0, 1000}, 1, 10); err == nil || !strings.Contains(err.Error(), "1001 bins") {
+		t.Errorf("1001 bins gave the error %v", err)
+	}
+	if err := writeHistogram(&buffer, []float64{1.6e12, 1.6e12 + 60000}, 0.001, 10); err == nil {
+		t.Errorf("a width of a microsecond on a minute of millisecond times was not an error")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// write a file by writing a temporary file in the same directory and renaming it into place,
+// so readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// IngestedFile records where a file of an ingested model run came from
+type IngestedFile struct {
+	// the path of the file relative to the data root
+	Path string `json:"path"`
+	// the file of the model run it was made from
+	Source         string    `json:"source"`
+	SourceModified time.Time `json:"sourceModified"`
+	SourceSHA256   string    `json:"sourceSha256"`
+	SHA256         string    `json:"sha256"`
+}
+
+// IngestManifest records the provenance of an ingested model run. it is written to manifest.json in the trial directory
+type IngestManifest struct {
+	Source   string         `json:"source"`
+	Ingested time.Time      `json:"ingested"`
+	Subject  int            `json:"subject"`
+	Block    string         `json:"block"`
+	Trial    string         `json:"trial"`
+	Files    []IngestedFile `json:"files"`
+}
+
+// a file to be written by an ingestion
+type ingestFile struct {
+	source string
+	target string
+	// convert the contents of the source to the contents of the target
+	convert func(source []byte) ([]byte, error)
+}
+
+// settings of a model run ingestion
+type ingestOptions struct {
+	subject int
+	block   string
+	trial   int
+	// set if the run has no utilization results
+	auto bool
+	// replace a trial that has already been ingested
+	force bool
+	// leave the source files in place instead of removing them
+	keep bool
+}
+
+// the files of a model run in a source directory and where they go in the dataset
+func ingestFiles(layout *Layout, source string, options ingestOptions) []ingestFile {
+	trialDir := layout.TrialDir(options.subject, options.block, layout.TrialName(options.trial))
+	blockDir := layout.BlockDir(options.subject, options.block)
+	copyFile := func(data []byte) ([]byte, error) { return data, nil }
+	files := []ingestFile{
+		{filepath.Join(source, "log.txt"), filepath.Join(trialDir, "data.txt"), func(data []byte) ([]byte, error) {
+			lines, err := stampModelLog(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return []byte(strings.Join(lines, "\n") + "\n"), nil
+		}},
+		{filepath.Join(source, "log.txt"), filepath.Join(trialDir, "log.txt"), copyFile},
+		{filepath.Join(source, "trace.txt"), filepath.Join(trialDir, "trace.txt"), copyFile},
+	}
+	if !options.auto {
+		for _, kind := range []string{"module", "subnetwork"} {
+			src := filepath.Join(source, utilizationResultFiles[kind])
+			kind := kind
+			files = append(files, ingestFile{src, filepath.Join(blockDir, kind+".txt"), func(data []byte) ([]byte, error) {
+				result, err := parseUtilizationResult(bytes.NewReader(data))
+				if err != nil {
+					return nil, err
+				}
+				table, err := result.Table(utilizationColumns[kind])
+				if err != nil {
+					return nil, err
+				}
+				var buffer bytes.Buffer
+				err = table.WriteCSV(&buffer)
+				return buffer.Bytes(), err
+			}})
+		}
+	}
+	return files
+}
+
+// check that an ingestion would not replace any file of an earlier one, unless it is forced. this covers the block's
+// utilization results as well as the trial
+func checkIngestTargets(layout *Layout, options ingestOptions) error {
+	if options.force {
+		return nil
+	}
+	trialDir := layout.TrialDir(options.subject, options.block, layout.TrialName(options.trial))
+	targets := []string{filepath.Join(trialDir, "manifest.json")}
+	for _, file := range ingestFiles(layout, "", options) {
+		targets = append(targets, file.target)
+	}
+	for _, target := range targets {
+		if _, err := os.Stat(target); err == nil {
+			return fmt.Errorf("%s already exists, use -force to replace it", target)
+		}
+	}
+	return nil
+}
+
+// convert the outputs of a model run in a source directory into a trial of the dataset.
+// everything is read and converted before anything is written, so a bad run leaves the dataset untouched
+func ingestModelRun(layout *Layout, source string, options ingestOptions) (*IngestManifest, error) {
+	trial := layout.TrialName(options.trial)
+	trialDir := layout.TrialDir(options.subject, options.block, trial)
+	if err := checkIngestTargets(layout, options); err != nil {
+		return nil, err
+	}
+
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &IngestManifest{Source: absSource, Ingested: time.Now().UTC(), Subject: options.subject,
+		Block: options.block, Trial: trial, Files: make([]IngestedFile, 0)}
+
+	// read and convert every file
+	files := ingestFiles(layout, absSource, options)
+	outputs := make([][]byte, len(files))
+	for index, file := range files {
+		info, err := os.Stat(file.source)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(file.source)
+		if err != nil {
+			return nil, err
+		}
+		if outputs[index], err = file.convert(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", file.source, err)
+		}
+		path, err := filepath.Rel(layout.Root, file.target)
+		if err != nil {
+			path = file.target
+		}
+		manifest.Files = append(manifest.Files, IngestedFile{Path: filepath.ToSlash(path), Source: file.source,
+			SourceModified: info.ModTime().UTC(), SourceSHA256: sha256Hex(data), SHA256: sha256Hex(outputs[index])})
+	}
+
+	// write the converted files and the manifest
+	for index, file := range files {
+		if err := writeFileAtomic(file.target, outputs[index]); err != nil {
+			return nil, err
+		}
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(trialDir, "manifest.json"), append(manifestData, '\n')); err != nil {
+		return nil, err
+	}
+
+	// clear the source directory for the next run
+	if !options.keep {
+		for _, file := range files {
+			if err := os.Remove(file.source); err != nil && !os.IsNotExist(err) {
+				return manifest, err
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// register the flags of an ingestion other than the source
+func addIngestFlags(flags *flag.FlagSet) *ingestOptions {
+	options := new(ingestOptions)
+	flags.IntVar(&options.subject, "s", 5, "The number of the subject to ingest into")
+	flags.StringVar(&options.block, "block", "", "The block to ingest into, e.g. block3")
+	flags.IntVar(&options.trial, "trial", 0, "The trial to ingest into")
+	flags.BoolVar(&options.auto, "auto", false, "The run has no utilization results")
+	flags.BoolVar(&options.force, "force", false, "Replace a trial that has already been ingested")
+	flags.BoolVar(&options.keep, "keep", false, "Leave the model's output files in the source directory")
+	return options
+}
+
+func init() {
+	addCommand(&command{
+		name:  "ingest-model",
+		args:  "<source directory>",
+		short: "convert a QN-ACTR run's output into a trial of the dataset",
+		long: `Ingest-model takes log.txt, trace.txt and the utilization result files the model wrote
+to a source directory and stores them in a trial of the dataset: the log converted to data.txt,
+the log and trace as they are, and the utilization results as module.txt and subnetwork.txt
+in the block directory. Files are written atomically and none of them, nor the manifest, is
+replaced without -force: if any already exists nothing is written. manifest.json in the trial
+directory records the source path, times and file hashes. The source files are removed
+afterwards unless -keep is set.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			options := addIngestFlags(flags)
+			return func(args []string) error {
+				if len(args) != 1 {
+					return fmt.Errorf("expected one source directory")
+				}
+				if options.block == "" {
+					return fmt.Errorf("-block is required")
+				}
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				manifest, err := ingestModelRun(layout, args[0], *options)
+				if err != nil {
+					return err
+				}
+				for _, file := range manifest.Files {
+					fmt.Printf("%s <- %s\n", file.Path, file.Source)
+				}
+				return nil
+			}
+		},
+	})
+}
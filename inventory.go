@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// the files looked for at each level of a dataset. names ending in .* match any extension
+var (
+	subjectFiles = []string{"weights.txt"}
+	blockFiles   = []string{"block.txt", "survey.txt", "module.txt", "subnetwork.txt"}
+	trialFiles   = []string{"data.txt", "task.txt", "responses.txt", "audio.*", "trace.txt"}
+)
+
+// SubjectInventory lists the blocks and files present for a subject
+type SubjectInventory struct {
+	Subject int              `json:"subject"`
+	Files   map[string]bool  `json:"files"`
+	Blocks  []BlockInventory `json:"blocks"`
+}
+
+// BlockInventory lists the trials and files present for a block, and its conditions if block.txt could be read
+type BlockInventory struct {
+	Name       string           `json:"name"`
+	Conditions *IVLevels        `json:"conditions"`
+	Files      map[string]bool  `json:"files"`
+	Trials     []TrialInventory `json:"trials"`
+}
+
+// TrialInventory lists the files present for a trial
+type TrialInventory struct {
+	Name  string          `json:"name"`
+	Files map[string]bool `json:"files"`
+}
+
+// check which of a list of files exist in a directory
+func filesPresent(dir string, names []string) map[string]bool {
+	present := make(map[string]bool, len(names))
+	for _, name := range names {
+		matches, _ := filepath.Glob(filepath.Join(dir, name))
+		present[strings.TrimSuffix(name, ".*")] = len(matches) > 0
+	}
+	return present
+}
+
+// take an inventory of a subject's directory
+func takeInventory(layout *Layout, subject int) SubjectInventory {
+	inventory := SubjectInventory{Subject: subject, Files: filesPresent(layout.SubjectDir(subject), subjectFiles),
+		Blocks: make([]BlockInventory, 0)}
+	for _, block := range layout.Blocks(subject) {
+		levels, _ := readBlockIVLevels(layout, subject, block)
+		blockInventory := BlockInventory{Name: block, Conditions: levels,
+			Files: filesPresent(layout.BlockDir(subject, block), blockFiles), Trials: make([]TrialInventory, 0)}
+		for _, trial := range layout.Trials(subject, block) {
+			blockInventory.Trials = append(blockInventory.Trials, TrialInventory{trial,
+				filesPresent(layout.TrialDir(subject, block, trial), trialFiles)})
+		}
+		inventory.Blocks = append(inventory.Blocks, blockInventory)
+	}
+	return inventory
+}
+
+// print inventories as a table with one row per trial
+func printInventoryTable(w io.Writer, inventories []SubjectInventory) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	columns := []string{"subject", "block", "trial", "practice", "targets", "speed", "oprange", "difficulty"}
+	// the file columns and the level of the dataset each belongs to
+	names := make([]string, 0)
+	levels := make([]int, 0)
+	for level, list := range [][]string{subjectFiles, blockFiles, trialFiles} {
+		for _, name := range list {
+			names = append(names, strings.TrimSuffix(name, ".*"))
+			levels = append(levels, level)
+		}
+	}
+	fmt.Fprintln(table, strings.Join(append(columns, names...), "\t"))
+
+	row := func(subject SubjectInventory, block *BlockInventory, trial *TrialInventory) {
+		cells := []string{fmt.Sprint(subject.Subject), "", "", "", "", "", "", ""}
+		var blockFiles, trialFiles map[string]bool
+		if block != nil {
+			cells[1] = block.Name
+			blockFiles = block.Files
+			if levels := block.Conditions; levels != nil {
+				copy(cells[3:], []string{fmt.Sprint(levels.Practice), fmt.Sprint(levels.TargetNumber), fmt.Sprint(levels.TargetSpeed),
+					fmt.Sprint(levels.AdditionDifficulty), fmt.Sprint(levels.TargetDifficulty)})
+			} else {
+				cells[3] = "no block.txt"
+			}
+		}
+		if trial != nil {
+			cells[2] = trial.Name
+			trialFiles = trial.Files
+		}
+		for index, name := range names {
+			// mark whether the file is present, or leave blank if its level does not exist
+			files := []map[string]bool{subject.Files, blockFiles, trialFiles}[levels[index]]
+			if files == nil {
+				cells = append(cells, "")
+			} else if files[name] {
+				cells = append(cells, "yes")
+			} else {
+				cells = append(cells, "-")
+			}
+		}
+		fmt.Fprintln(table, strings.Join(cells, "\t"))
+	}
+
+	for _, subject := range inventories {
+		if len(subject.Blocks) == 0 {
+			row(subject, nil, nil)
+		}
+		for b := range subject.Blocks {
+			block := &subject.Blocks[b]
+			if len(block.Trials) == 0 {
+				row(subject, block, nil)
+			}
+			for t := range block.Trials {
+				row(subject, block, &block.Trials[t])
+			}
+		}
+	}
+	return table.Flush()
+}
+
+func init() {
+	addCommand(&command{
+		name:  "inventory",
+		short: "list the blocks, trials and files present for each subject",
+		long: `Inventory reports for each subject which blocks and trials exist, which of the data, task,
+block, survey, weights, responses, audio, module, subnetwork and trace files are present, and
+the conditions of each block. Blank cells are files of a level that does not exist, such as the
+trial files of a block without trials.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			var subject int
+			var asJSON bool
+			flags.IntVar(&subject, "s", 0, "The number of the subject (default every subject in the root)")
+			flags.BoolVar(&asJSON, "json", false, "Print the inventory as JSON instead of a table")
+			return func(args []string) error {
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				subjects := layout.Subjects()
+				if subject != 0 {
+					subjects = []int{subject}
+				}
+				inventories := make([]SubjectInventory, 0, len(subjects))
+				for _, s := range subjects {
+					inventories = append(inventories, takeInventory(layout, s))
+				}
+				if asJSON {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					return encoder.Encode(inventories)
+				}
+				return printInventoryTable(os.Stdout, inventories)
+			}
+		},
+	})
+}
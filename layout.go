@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the layout used by ExpDataServer when it writes output
+const defaultLayout = "subject{subject}/block{block}/trial{trial}"
+
+// Layout describes where the subject, block and trial directories of a dataset live.
+// The template is a slash separated path relative to Root in which {subject}, {block} and {trial}
+// each appear once, in that order, in separate path segments, with trial in the last segment.
+type Layout struct {
+	Root     string
+	Template string
+
+	// the registry of subjects and the file it was read from, or nil and empty if the dataset has none
+	registry     subjectRegistry
+	registryPath string
+
+	// path segments of the template
+	segments []string
+	// indices of the segments containing each placeholder
+	subjectIndex, blockIndex, trialIndex int
+}
+
+// parse a layout template relative to a data root
+func parseLayout(root, template string) (*Layout, error) {
+	layout := &Layout{Root: root, Template: template, segments: strings.Split(template, "/")}
+	layout.subjectIndex, layout.blockIndex, layout.trialIndex = -1, -1, -1
+	for index, segment := range layout.segments {
+		for placeholder, target := range map[string]*int{"{subject}": &layout.subjectIndex,
+			"{block}": &layout.blockIndex, "{trial}": &layout.trialIndex} {
+			switch strings.Count(segment, placeholder) {
+			case 0:
+			case 1:
+				if *target != -1 {
+					return nil, fmt.Errorf("layout %q has more than one %s", template, placeholder)
+				}
+				*target = index
+			default:
+				return nil, fmt.Errorf("layout %q has more than one %s", template, placeholder)
+			}
+		}
+	}
+	if layout.subjectIndex == -1 || layout.blockIndex == -1 || layout.trialIndex == -1 {
+		return nil, fmt.Errorf("layout %q must contain {subject}, {block} and {trial}", template)
+	}
+	if !(layout.subjectIndex < layout.blockIndex && layout.blockIndex < layout.trialIndex) ||
+		layout.trialIndex != len(layout.segments)-1 {
+		return nil, fmt.Errorf("layout %q must have {subject}, {block} and {trial} in separate segments, in that order, with {trial} last", template)
+	}
+	return layout, nil
+}
+
+// join the segments between two indices onto a directory
+func (l *Layout) join(dir string, from, to int) string {
+	return filepath.Join(append([]string{dir}, l.segments[from:to]...)...)
+}
+
+// get the directory of a subject
+func (l *Layout) SubjectDir(subject int) string {
+	dir := l.join(l.Root, 0, l.subjectIndex)
+	return filepath.Join(dir, l.SubjectName(subject))
+}
+
+// get the directory of a block of a subject, given the block directory name
+func (l *Layout) BlockDir(subject int, block string) string {
+	return filepath.Join(l.join(l.SubjectDir(subject), l.subjectIndex+1, l.blockIndex), block)
+}
+
+// get the directory of a trial, given the block and trial directory names
+func (l *Layout) TrialDir(subject int, block, trial string) string {
+	return filepath.Join(l.join(l.BlockDir(subject, block), l.blockIndex+1, l.trialIndex), trial)
+}
+
+// get the directory name of a subject from its number
+func (l *Layout) SubjectName(subject int) string {
+	return strings.Replace(l.segments[l.subjectIndex], "{subject}", strconv.Itoa(subject), 1)
+}
+
+// get the directory name of a block from its number
+func (l *Layout) BlockName(block int) string {
+	return strings.Replace(l.segments[l.blockIndex], "{block}", strconv.Itoa(block), 1)
+}
+
+// get the directory name of a trial from its number
+func (l *Layout) TrialName(trial int) string {
+	return strings.Replace(l.segments[l.trialIndex], "{trial}", strconv.Itoa(trial), 1)
+}
+
+// get the names of the block directories of a subject
+func (l *Layout) Blocks(subject int) []string {
+	dir := l.join(l.SubjectDir(subject), l.subjectIndex+1, l.blockIndex)
+	return getDirsInDirMatching(dir, segmentRegexp(l.segments[l.blockIndex], "{block}"))
+}
+
+// get the names of the trial directories of a block
+func (l *Layout) Trials(subject int, block string) []string {
+	dir := l.join(l.BlockDir(subject, block), l.blockIndex+1, l.trialIndex)
+	return getDirsInDirMatching(dir, segmentRegexp(l.segments[l.trialIndex], "{trial}"))
+}
+
+// get the numbers of the subject directories in the dataset, in increasing order
+func (l *Layout) Subjects() []int {
+	dir := l.join(l.Root, 0, l.subjectIndex)
+	re := segmentRegexp(l.segments[l.subjectIndex], "{subject}")
+	subjects := make([]int, 0)
+	for _, name := range getDirsInDirMatching(dir, re) {
+		subject, _ := strconv.Atoi(re.FindStringSubmatch(name)[1])
+		subjects = append(subjects, subject)
+	}
+	sort.Ints(subjects)
+	return subjects
+}
+
+// make a regex matching a template segment with the placeholder replaced by a number
+func segmentRegexp(segment, placeholder string) *regexp.Regexp {
+	parts := strings.SplitN(segment, placeholder, 2)
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(parts[0]) + `(\d+)` + regexp.QuoteMeta(parts[1]) + `$`)
+}
+
+func getDirsInDirMatching(dirname string, re *regexp.Regexp) []string {
+	// get file info slice
+	fileInfos, err := ioutil.ReadDir(dirname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read contents of %s\n", dirname)
+		return nil
+	}
+	// make name slice
+	names := make([]string, 0, len(fileInfos))
+	// fill name slice
+	for _, fi := range fileInfos {
+		if fi.IsDir() && re.MatchString(fi.Name()) {
+			names = append(names, fi.Name())
+		}
+	}
+	return names
+}
+
+// a writer around stdout that is not closed with the result file
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// get the path of a result file of a subject given the --out setting.
+// an empty setting writes into the subject directory, a directory (existing or ending in a separator)
+// gets a subject directory of its own, and anything else is used as the file path
+func outputPath(out string, layout *Layout, subject int, filename string) string {
+	if out == "" {
+		return filepath.Join(layout.SubjectDir(subject), filename)
+	}
+	if isDirSetting(out) {
+		return filepath.Join(out, layout.SubjectName(subject), filename)
+	}
+	return out
+}
+
+// test if an --out setting names a directory
+func isDirSetting(out string) bool {
+	if strings.HasSuffix(out, "/") || strings.HasSuffix(out, string(filepath.Separator)) {
+		return true
+	}
+	fi, err := os.Stat(out)
+	return err == nil && fi.IsDir()
+}
+
+// create a result file of a subject given the --out setting. "-" writes to stdout
+func createOutput(out string, layout *Layout, subject int, filename string) (io.WriteCloser, error) {
+	if out == "-" {
+		return layout.joinSubjects(nopWriteCloser{os.Stdout}), nil
+	}
+	path := outputPath(out, layout, subject, filename)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return layout.joinSubjects(file), nil
+}
+
+// create the result file of a command that analyses a dataset. "-" writes to stdout
+func (l *Layout) openOutput(out string) (io.WriteCloser, error) {
+	if out == "-" {
+		return l.joinSubjects(nopWriteCloser{os.Stdout}), nil
+	}
+	file, err := os.Create(out)
+	if err != nil {
+		return nil, err
+	}
+	return l.joinSubjects(file), nil
+}
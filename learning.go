@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var trailingNumber = regexp.MustCompile(`(\d+)$`)
+
+// the number at the end of a block or trial name, so block10 comes after block9
+func nameNumber(name string) int {
+	n, _ := strconv.Atoi(trailingNumber.FindString(name))
+	return n
+}
+
+// test if an iteration of a subject was done before another, by block, trial and iteration
+func doneBefore(a, b Observation) bool {
+	if a.Block != b.Block {
+		return nameNumber(a.Block) < nameNumber(b.Block)
+	}
+	if a.Trial != b.Trial {
+		return nameNumber(a.Trial) < nameNumber(b.Trial)
+	}
+	return a.Index < b.Index
+}
+
+// the factors that make a series of iterations in the learning analysis
+var learningFactors = []string{"subject", "practice", "type", "speed", "difficulty", "oprange"}
+
+// the learning analyses of the iterations of a subject under a condition, in the order they were done
+type learningSeries struct {
+	Levels []string
+	N      int
+	// the split with the largest Welch t statistic of the iterations before it against those from it, and its p value
+	Split          int
+	SplitT, SplitP float64
+	// the number of leading iterations that have to be left out before the rest have no linear trend,
+	// as movingmodel.R refits them, and the trend of the whole series
+	TrendCutoff   int
+	Slope, SlopeP float64
+	// the iterations to leave out: the best split if it is significant, or the trend cutoff if that is later
+	Cutoff int
+}
+
+// analyse a series of values for where performance stabilises
+func analyseLearning(values []float64, alpha float64) learningSeries {
+	s := learningSeries{N: len(values), SplitT: math.NaN(), SplitP: math.NaN()}
+	// splits are compared by t rather than p, as the degrees of freedom of Welch's test differ between splits
+	// and the smallest p can come before a sharp change
+	for split := 3; split <= len(values)-3; split++ {
+		t, _, p := welchTest(values[:split], values[split:])
+		if !math.IsNaN(t) && (math.IsNaN(s.SplitT) || math.Abs(t) > math.Abs(s.SplitT)) {
+			s.Split, s.SplitT, s.SplitP = split, t, p
+		}
+	}
+
+	positions := make([]float64, len(values))
+	for index := range positions {
+		positions[index] = float64(index + 1)
+	}
+	s.Slope, _, s.SlopeP = slopeTest(positions, values)
+	s.TrendCutoff = -1
+	for cutoff := 0; cutoff <= len(values)-3; cutoff++ {
+		if _, _, p := slopeTest(positions[cutoff:], values[cutoff:]); p >= alpha {
+			s.TrendCutoff = cutoff
+			break
+		}
+	}
+
+	if s.SplitP < alpha {
+		s.Cutoff = s.Split
+	}
+	if s.TrendCutoff > s.Cutoff {
+		s.Cutoff = s.TrendCutoff
+	}
+	return s
+}
+
+// split observations into a series for each subject and condition, ordered by block, trial and iteration, and analyse them
+func learningAnalyses(observations []Observation, measure string, alpha float64) []learningSeries {
+	analyses := make([]learningSeries, 0)
+	for _, group := range groupObservations(observations, learningFactors) {
+		members := group.Members
+		sort.SliceStable(members, func(i, j int) bool { return doneBefore(observations[members[i]], observations[members[j]]) })
+		values := make([]float64, 0, len(members))
+		for _, index := range members {
+			if value := observations[index].measure(measure); !math.IsNaN(value) {
+				values = append(values, value)
+			}
+		}
+		if len(values) < 6 {
+			continue
+		}
+		s := analyseLearning(values, alpha)
+		s.Levels = group.Levels
+		analyses = append(analyses, s)
+	}
+	return analyses
+}
+
+// write the analysis of each series, then the cutoff recommended for each subject and for everyone: the
+// largest cutoff of any of their series, so that no series is left with a trend
+func writeLearning(w io.Writer, analyses []learningSeries) {
+	fmt.Fprintln(w, strings.Join(learningFactors, ", ")+", n, split, splitT, splitP, trendCutoff, slope, slopeP, cutoff")
+	subjects := make([]string, 0)
+	cutoffs := make(map[string]int)
+	overall := 0
+	for _, s := range analyses {
+		trendCutoff := "NA"
+		if s.TrendCutoff >= 0 {
+			trendCutoff = strconv.Itoa(s.TrendCutoff)
+		}
+		fmt.Fprintf(w, "%s, %d, %d, %s, %s, %s, %s, %s, %d\n", strings.Join(s.Levels, ", "), s.N, s.Split,
+			formatStat(s.SplitT), formatStat(s.SplitP), trendCutoff, formatStat(s.Slope), formatStat(s.SlopeP), s.Cutoff)
+		subject := s.Levels[0]
+		if _, ok := cutoffs[subject]; !ok {
+			subjects = append(subjects, subject)
+		}
+		if s.Cutoff > cutoffs[subject] {
+			cutoffs[subject] = s.Cutoff
+		}
+		if s.Cutoff > overall {
+			overall = s.Cutoff
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "subject, cutoff")
+	for _, subject := range subjects {
+		fmt.Fprintf(w, "%s, %d\n", subject, cutoffs[subject])
+	}
+	fmt.Fprintf(w, "all, %d\n", overall)
+}
+
+func init() {
+	addCommand(&command{
+		name:  "learning",
+		short: "find how many iterations of each condition are warm-up",
+		long: `Learning looks for practice effects in a measure, by default the completion time, across the
+iterations of each subject under each condition, in the order they were done. It runs the two
+analyses of bestsplit.R and movingmodel.R:
+
+The split is the number of leading iterations whose Welch t test against the rest has the
+largest t statistic, trying every split that leaves at least 3 iterations on each side, and its
+p value. Unlike bestsplit.R, which took the smallest p value, splits are compared by t because
+the degrees of freedom of Welch's test vary between splits, which can put the smallest p before
+a sharp change, and the iteration at the split is only in the second group.
+
+The trend cutoff is the number of leading iterations that have to be left out before a linear
+regression of the rest on iteration number has no slope significant at -alpha, or NA if every
+cut leaves a trend. movingmodel.R tested the intercept of these regressions; the slope is what
+shows a trend.
+
+The cutoff of a series is the split if its p value is below -alpha, or the trend cutoff if that
+is larger. The second table recommends, for each subject and for everyone, the largest cutoff of
+their series, the number of iterations to discard from the start of each condition. Practice
+blocks are left out unless -practice is given; series with fewer than 6 values are skipped.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			selection := addAnalysisSelectionFlags(flags)
+			caching := addCacheFlags(flags)
+			var measure, where, source, out string
+			var alpha float64
+			var practice bool
+			flags.StringVar(&measure, "measure", "complete", "The measure to analyse")
+			flags.Float64Var(&alpha, "alpha", 0.05, "The significance level of the tests")
+			flags.BoolVar(&practice, "practice", false, "Analyse practice blocks too")
+			flags.StringVar(&where, "where", "", "Only use the iterations for which an expression is true")
+			flags.StringVar(&source, "source", "", "Only use trials from this source, human or model")
+			flags.StringVar(&out, "out", "-", "The file to write, or - for stdout")
+			return func(args []string) error {
+				if !isMeasure(measure) {
+					return fmt.Errorf("unknown measure %q", measure)
+				}
+				if alpha <= 0 || alpha >= 1 {
+					return fmt.Errorf("-alpha must be between 0 and 1")
+				}
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				if where != "" {
+					if selection.options.where, err = parseFilter(where); err != nil {
+						return err
+					}
+				}
+				if selection.options.cache, err = caching.cache(layout); err != nil {
+					return err
+				}
+				observations, err := loadObservations(layout, selection.subjects(layout), source, selection.options)
+				if err != nil {
+					return err
+				}
+				if !practice {
+					observations = selectTask(observations, "all", nil)
+				}
+
+				w, err := layout.openOutput(out)
+				if err != nil {
+					return err
+				}
+				writeLearning(w, learningAnalyses(observations, measure, alpha))
+				return w.Close()
+			}
+		},
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// R's sleep data: t.test(extra ~ group, data = sleep) gives t = -1.8608, df = 17.776, p-value = 0.07939
+func TestWelchTest(t *testing.T) {
+	first := []float64{0.7, -1.6, -0.2, -1.2, -0.1, 3.4, 3.7, 0.8, 0.0, 2.0}
+	second := []float64{1.9, 0.8, 1.1, 0.1, -0.1, 4.4, 5.5, 1.6, 4.6, 3.4}
+	statistic, df, p := welchTest(first, second)
+	checkClose(t, "t", statistic, -1.8608134674868526, 1e-9)
+	checkClose(t, "df", df, 17.776473516178495, 1e-9)
+	checkClose(t, "p", p, 0.0793941401873581, 1e-6)
+}
+
+// R's cars data: lm(dist ~ speed, data = cars) gives a slope of 3.9324 with a standard error of 0.4155 and
+// p = 1.49e-12
+func TestSlopeTest(t *testing.T) {
+	speed := []float64{4, 4, 7, 7, 8, 9, 10, 10, 10, 11, 11, 12, 12, 12, 12, 13, 13, 13, 13, 14, 14, 14, 14, 15, 15, 15,
+		16, 16, 17, 17, 17, 18, 18, 18, 18, 19, 19, 19, 20, 20, 20, 20, 20, 22, 23, 24, 24, 24, 24, 25}
+	dist := []float64{2, 10, 4, 22, 16, 10, 18, 26, 34, 17, 28, 14, 20, 24, 28, 26, 34, 34, 46, 26, 36, 60, 80, 20, 26, 54,
+		32, 40, 32, 40, 50, 42, 56, 76, 84, 36, 46, 68, 32, 48, 52, 56, 64, 66, 54, 70, 92, 93, 120, 85}
+	slope, se, p := slopeTest(speed, dist)
+	checkClose(t, "slope", slope, 3.9324087591240886, 1e-9)
+	checkClose(t, "se", se, 0.4155127766571223, 1e-9)
+	if p < 1.48e-12 || p > 1.50e-12 {
+		t.Errorf("p = %g, expected 1.49e-12", p)
+	}
+
+	// pairs with a NaN on either side are left out, as lm's na.omit does
+	withNaN, _, pNaN := slopeTest(append([]float64{math.NaN(), 30}, speed...), append([]float64{200, math.NaN()}, dist...))
+	checkClose(t, "slope without NaN", withNaN, 3.9324087591240886, 1e-9)
+	checkClose(t, "p without NaN", pNaN, p, 1e-9)
+}
+
+// a series that drops from 5 to 3 after 10 iterations must have its split and cutoff at the change
+func TestLearningCutoff(t *testing.T) {
+	noise := []float64{0.1, -0.1, 0.05, -0.05, 0}
+	values := make([]float64, 40)
+	for index := range values {
+		values[index] = 3 + noise[index%len(noise)]
+		if index < 10 {
+			values[index] += 2
+		}
+	}
+	s := analyseLearning(values, 0.05)
+	if s.Split != 10 || s.SplitP >= 0.05 || s.Cutoff != 10 {
+		t.Errorf("split at %d with p %g and cutoff %d, expected a significant split and cutoff at 10", s.Split, s.SplitP, s.Cutoff)
+	}
+	if s.TrendCutoff < 1 || s.TrendCutoff > 10 {
+		t.Errorf("trend cutoff %d, expected the change to leave a trend until no later than 10", s.TrendCutoff)
+	}
+
+	// with random noise the split still finds the change, and the rest may have a trend by chance
+	random := rand.New(rand.NewSource(1))
+	for index := range values {
+		values[index] = 3 + 0.3*random.NormFloat64()
+		if index < 10 {
+			values[index] += 2
+		}
+	}
+	s = analyseLearning(values, 0.05)
+	if s.Split != 10 || s.SplitP >= 0.05 {
+		t.Errorf("split at %d with p %g, expected a significant split at 10", s.Split, s.SplitP)
+	}
+	want := 10
+	if s.TrendCutoff > want {
+		want = s.TrendCutoff
+	}
+	if s.Cutoff != want {
+		t.Errorf("cutoff %d, expected the larger of the split and the trend cutoff %d", s.Cutoff, s.TrendCutoff)
+	}
+}
+
+// the recommended cutoff must not depend on the order the iterations were loaded in
+func TestLearningReproducible(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	observations := make([]Observation, 0)
+	levels := &IVLevels{TargetNumber: 1}
+	for trial := 0; trial < 4; trial++ {
+		for index := 0; index < 12; index++ {
+			complete := 3 + 0.3*random.NormFloat64()
+			if trial == 0 && index < 8 {
+				complete += 2
+			}
+			observations = append(observations, Observation{Iteration: Iteration{Subject: 1, Block: "block3",
+				Trial: fmt.Sprint("trial", trial), Index: index, Levels: levels, Complete: complete}, Type: "targeting"})
+		}
+	}
+	want := learningAnalyses(observations, "complete", 0.05)
+	if len(want) != 1 || want[0].Cutoff != 8 {
+		t.Fatalf("got %+v, expected one series with a cutoff of 8", want)
+	}
+	for round := 0; round < 5; round++ {
+		shuffled := append([]Observation{}, observations...)
+		random.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		got := learningAnalyses(shuffled, "complete", 0.05)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("shuffled iterations gave %+v, expected %+v", got, want)
+		}
+	}
+}
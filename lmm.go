@@ -0,0 +1,454 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// a linear mixed model formula such as complete ~ speed*difficulty*oprange + (1|subject). the fixed effects are
+// factors with treatment contrasts, as R codes factors, and the random effect is an intercept for each level of a
+// grouping factor
+type mixedFormula struct {
+	response string
+	// the fixed terms, each a list of factors that interact, main effects first
+	terms [][]string
+	group string
+}
+
+// parse a formula with random intercepts for one grouping factor
+func parseMixedFormula(text string) (*mixedFormula, error) {
+	sides := strings.SplitN(text, "~", 2)
+	if len(sides) != 2 {
+		return nil, fmt.Errorf("formula %q has no ~", text)
+	}
+	formula := &mixedFormula{response: strings.TrimSpace(sides[0])}
+	if !isMeasure(formula.response) {
+		return nil, fmt.Errorf("unknown measure %q", formula.response)
+	}
+	seen := make(map[string]bool)
+	addTerm := func(term []string) {
+		sorted := append([]string{}, term...)
+		sort.Strings(sorted)
+		key := strings.Join(sorted, ":")
+		if !seen[key] {
+			seen[key] = true
+			formula.terms = append(formula.terms, term)
+		}
+	}
+	for _, part := range strings.Split(sides[1], "+") {
+		part = strings.Replace(strings.TrimSpace(part), " ", "", -1)
+		switch {
+		case part == "" || part == "1":
+		case strings.HasPrefix(part, "("):
+			if !strings.HasPrefix(part, "(1|") || !strings.HasSuffix(part, ")") {
+				return nil, fmt.Errorf("unsupported random effect %q, only random intercepts such as (1|subject) are", part)
+			}
+			group := part[3 : len(part)-1]
+			if !isFactor(group) {
+				return nil, fmt.Errorf("unknown factor %q", group)
+			}
+			if formula.group != "" && formula.group != group {
+				return nil, fmt.Errorf("only one grouping factor is supported")
+			}
+			formula.group = group
+		case strings.Contains(part, "*"):
+			// a*b*c stands for every combination of its factors
+			factors := strings.Split(part, "*")
+			for mask := 1; mask < 1<<uint(len(factors)); mask++ {
+				term := make([]string, 0)
+				for f, factor := range factors {
+					if mask&(1<<uint(f)) != 0 {
+						term = append(term, factor)
+					}
+				}
+				addTerm(term)
+			}
+		default:
+			addTerm(strings.Split(part, ":"))
+		}
+	}
+	if formula.group == "" {
+		return nil, fmt.Errorf("formula %q has no random intercept such as (1|subject)", text)
+	}
+	for _, term := range formula.terms {
+		for _, factor := range term {
+			if !isFactor(factor) {
+				return nil, fmt.Errorf("unknown factor %q", factor)
+			}
+			if factor == formula.group {
+				return nil, fmt.Errorf("%s is both a fixed and a grouping factor", factor)
+			}
+		}
+	}
+	// R orders the terms of a model by how many factors interact
+	sort.SliceStable(formula.terms, func(i, j int) bool { return len(formula.terms[i]) < len(formula.terms[j]) })
+	return formula, nil
+}
+
+// the fixed effect design of observations: an intercept and a column for each combination of the non-reference
+// levels of the factors of each term, with the first factor varying fastest as in R
+func designMatrix(observations []Observation, terms [][]string) ([]string, [][]float64) {
+	levels := make(map[string][]string)
+	for _, term := range terms {
+		for _, factor := range term {
+			if _, ok := levels[factor]; ok {
+				continue
+			}
+			seen := make(map[string]bool)
+			for _, o := range observations {
+				if level := o.level(factor); !seen[level] {
+					seen[level] = true
+					levels[factor] = append(levels[factor], level)
+				}
+			}
+			sort.Slice(levels[factor], func(i, j int) bool { return levelLess(levels[factor][i], levels[factor][j]) })
+		}
+	}
+
+	type column struct {
+		name   string
+		levels map[string]string
+	}
+	columns := []column{{"(Intercept)", nil}}
+	for _, term := range terms {
+		combinations := []column{{"", map[string]string{}}}
+		for _, factor := range term {
+			next := make([]column, 0)
+			for _, level := range levels[factor][1:] {
+				for _, c := range combinations {
+					name := factor + level
+					if c.name != "" {
+						name = c.name + ":" + name
+					}
+					chosen := map[string]string{factor: level}
+					for f, l := range c.levels {
+						chosen[f] = l
+					}
+					next = append(next, column{name, chosen})
+				}
+			}
+			combinations = next
+		}
+		columns = append(columns, combinations...)
+	}
+
+	names := make([]string, len(columns))
+	for index, c := range columns {
+		names[index] = c.name
+	}
+	x := make([][]float64, len(observations))
+	for row, o := range observations {
+		x[row] = make([]float64, len(columns))
+		for index, c := range columns {
+			x[row][index] = 1
+			for factor, level := range c.levels {
+				if o.level(factor) != level {
+					x[row][index] = 0
+					break
+				}
+			}
+		}
+	}
+	return names, x
+}
+
+// the Cholesky factor of a symmetric positive definite matrix, or false if it is not positive definite
+func cholesky(a [][]float64) ([][]float64, bool) {
+	n := len(a)
+	l := make([][]float64, n)
+	for i := range l {
+		l[i] = make([]float64, n)
+		for j := 0; j <= i; j++ {
+			sum := a[i][j]
+			for k := 0; k < j; k++ {
+				sum -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				if sum <= 1e-10*math.Abs(a[i][i]) {
+					return nil, false
+				}
+				l[i][i] = math.Sqrt(sum)
+			} else {
+				l[i][j] = sum / l[j][j]
+			}
+		}
+	}
+	return l, true
+}
+
+// solve a x = b given the Cholesky factor of a
+func choleskySolve(l [][]float64, b []float64) []float64 {
+	n := len(l)
+	x := make([]float64, n)
+	for i := 0; i < n; i++ {
+		sum := b[i]
+		for k := 0; k < i; k++ {
+			sum -= l[i][k] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	for i := n - 1; i >= 0; i-- {
+		sum := x[i]
+		for k := i + 1; k < n; k++ {
+			sum -= l[k][i] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	return x
+}
+
+// the sums a group of observations contributes to the mixed model equations
+type groupSums struct {
+	n   int
+	xtx [][]float64
+	xty []float64
+	yty float64
+	// the column sums of the design and the sum of the responses
+	sx []float64
+	sy float64
+}
+
+// a fitted linear mixed model
+type mixedModel struct {
+	names                []string
+	estimates, se, t     []float64
+	groupVariance        float64
+	residualVariance     float64
+	observations, groups int
+	// -2 times the restricted log likelihood, as lme4 reports it
+	reml float64
+}
+
+// the generalised least squares fit of the fixed effects for a ratio of the group variance to the residual
+// variance. it returns the Cholesky factor of X'V^-1 X, the estimates, the residual variance and the REML criterion
+func (m *mixedModel) fitRatio(groups []groupSums, ratio float64) ([][]float64, []float64, float64, float64, bool) {
+	p := len(m.names)
+	xvx := make([][]float64, p)
+	for i := range xvx {
+		xvx[i] = make([]float64, p)
+	}
+	xvy := make([]float64, p)
+	yvy, logDetV := 0.0, 0.0
+	for _, g := range groups {
+		// V is I + ratio J within a group, whose inverse is I - c J
+		c := ratio / (1 + float64(g.n)*ratio)
+		logDetV += math.Log(1 + float64(g.n)*ratio)
+		for i := 0; i < p; i++ {
+			for j := 0; j < p; j++ {
+				xvx[i][j] += g.xtx[i][j] - c*g.sx[i]*g.sx[j]
+			}
+			xvy[i] += g.xty[i] - c*g.sx[i]*g.sy
+		}
+		yvy += g.yty - c*g.sy*g.sy
+	}
+	l, ok := cholesky(xvx)
+	if !ok {
+		return nil, nil, 0, 0, false
+	}
+	beta := choleskySolve(l, xvy)
+	rvr := yvy
+	for i := range beta {
+		rvr -= beta[i] * xvy[i]
+	}
+	df := float64(m.observations - p)
+	variance := rvr / df
+	logDetXVX := 0.0
+	for i := range l {
+		logDetXVX += 2 * math.Log(l[i][i])
+	}
+	reml := df*(1+math.Log(2*math.Pi*variance)) + logDetV + logDetXVX
+	return l, beta, variance, reml, true
+}
+
+// fit a linear mixed model with random intercepts by restricted maximum likelihood. the residual variance and
+// fixed effects are profiled out, leaving the ratio of the variances to search for
+func fitMixedModel(observations []Observation, formula *mixedFormula) (*mixedModel, error) {
+	kept := make([]Observation, 0, len(observations))
+	for _, o := range observations {
+		if !math.IsNaN(o.measure(formula.response)) {
+			kept = append(kept, o)
+		}
+	}
+	names, x := designMatrix(kept, formula.terms)
+	m := &mixedModel{names: names, observations: len(kept)}
+	p := len(names)
+	if m.observations <= p {
+		return nil, fmt.Errorf("%d observations are too few for %d fixed effects", m.observations, p)
+	}
+
+	byGroup := make(map[string]*groupSums)
+	order := make([]string, 0)
+	for row, o := range kept {
+		key := o.level(formula.group)
+		g, ok := byGroup[key]
+		if !ok {
+			g = &groupSums{xtx: make([][]float64, p), xty: make([]float64, p), sx: make([]float64, p)}
+			for i := range g.xtx {
+				g.xtx[i] = make([]float64, p)
+			}
+			byGroup[key] = g
+			order = append(order, key)
+		}
+		y := o.measure(formula.response)
+		g.n++
+		g.yty += y * y
+		g.sy += y
+		for i := 0; i < p; i++ {
+			g.sx[i] += x[row][i]
+			g.xty[i] += x[row][i] * y
+			for j := 0; j < p; j++ {
+				g.xtx[i][j] += x[row][i] * x[row][j]
+			}
+		}
+	}
+	groups := make([]groupSums, len(order))
+	for index, key := range order {
+		groups[index] = *byGroup[key]
+	}
+	m.groups = len(groups)
+	if m.groups < 2 {
+		return nil, fmt.Errorf("need at least 2 levels of %s, have %d", formula.group, m.groups)
+	}
+
+	criterion := func(logRatio float64) float64 {
+		_, _, _, reml, ok := m.fitRatio(groups, math.Exp(logRatio))
+		if !ok {
+			return math.Inf(1)
+		}
+		return reml
+	}
+	if _, _, _, _, ok := m.fitRatio(groups, 0); !ok {
+		return nil, fmt.Errorf("the fixed effects cannot be estimated, some combinations of levels have no observations")
+	}
+	// scan the log ratio for the best bracket, then narrow it by golden section search
+	low, high := -20.0, 10.0
+	best, bestValue := low, criterion(low)
+	for step := low; step <= high; step += 0.5 {
+		if value := criterion(step); value < bestValue {
+			best, bestValue = step, value
+		}
+	}
+	a, b := best-0.5, best+0.5
+	golden := (math.Sqrt(5) - 1) / 2
+	c, d := b-golden*(b-a), a+golden*(b-a)
+	for b-a > 1e-9 {
+		if criterion(c) < criterion(d) {
+			b = d
+		} else {
+			a = c
+		}
+		c, d = b-golden*(b-a), a+golden*(b-a)
+	}
+	ratio := math.Exp((a + b) / 2)
+	// the variance of the groups may be estimated as zero
+	_, _, _, atZero, _ := m.fitRatio(groups, 0)
+	if atZero <= criterion((a+b)/2) {
+		ratio = 0
+	}
+
+	l, beta, variance, reml, _ := m.fitRatio(groups, ratio)
+	m.estimates, m.reml = beta, reml
+	m.residualVariance = variance
+	m.groupVariance = ratio * variance
+	m.se, m.t = make([]float64, p), make([]float64, p)
+	for i := 0; i < p; i++ {
+		unit := make([]float64, p)
+		unit[i] = 1
+		m.se[i] = math.Sqrt(variance * choleskySolve(l, unit)[i])
+		m.t[i] = beta[i] / m.se[i]
+	}
+	return m, nil
+}
+
+// write the fixed effects, variance components and fit of a model as three tables
+func writeMixedModel(w io.Writer, m *mixedModel, group string) {
+	fmt.Fprintln(w, "term, estimate, se, t")
+	for i, name := range m.names {
+		fmt.Fprintf(w, "%s, %s, %s, %s\n", name, formatStat(m.estimates[i]), formatStat(m.se[i]), formatStat(m.t[i]))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "group, name, variance, sd")
+	fmt.Fprintf(w, "%s, (Intercept), %s, %s\n", group, formatStat(m.groupVariance), formatStat(math.Sqrt(m.groupVariance)))
+	fmt.Fprintf(w, "Residual, , %s, %s\n", formatStat(m.residualVariance), formatStat(math.Sqrt(m.residualVariance)))
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "observations, groups, reml")
+	fmt.Fprintf(w, "%d, %d, %s\n", m.observations, m.groups, formatStat(m.reml))
+}
+
+func init() {
+	addCommand(&command{
+		name:  "lmm",
+		short: "fit a linear mixed model to the iterations by REML",
+		long: `Lmm fits a linear mixed model to the selected iterations by restricted maximum likelihood, as
+lme4's lmer does, and writes the estimate, standard error and t value of each fixed effect, the
+variance components and the REML criterion.
+
+The -formula has a measure on the left and factors on the right: a*b stands for a, b and their
+interaction a:b, and (1|subject) gives each subject a random intercept. The fixed factors are
+coded with treatment contrasts against their first level, as R codes factors, so numbers such
+as speed are levels rather than covariates. Only random intercepts of one grouping factor are
+supported. Iterations without a value of the measure are left out.
+
+Practice blocks are always left out and -type chooses the type of task (addition, targeting
+or main, or all for every type). -where, -source and -outliers select iterations as for anova.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			selection := addAnalysisSelectionFlags(flags)
+			caching := addCacheFlags(flags)
+			outliers := addOutlierFlags(flags)
+			var formulaText, taskFilter, where, source, out string
+			flags.StringVar(&formulaText, "formula", "complete ~ speed*difficulty*oprange + (1|subject)", "The model to fit")
+			flags.StringVar(&taskFilter, "type", "main", "The type of task to analyse: addition, targeting, main or all")
+			flags.StringVar(&where, "where", "", "Only use the iterations for which an expression is true")
+			flags.StringVar(&source, "source", "", "Only use trials from this source, human or model")
+			flags.StringVar(&out, "out", "-", "The file to write, or - for stdout")
+			return func(args []string) error {
+				formula, err := parseMixedFormula(formulaText)
+				if err != nil {
+					return err
+				}
+				rule, err := outliers.rule()
+				if err != nil {
+					return err
+				}
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				if where != "" {
+					if selection.options.where, err = parseFilter(where); err != nil {
+						return err
+					}
+				}
+				if selection.options.cache, err = caching.cache(layout); err != nil {
+					return err
+				}
+				observations, err := loadObservations(layout, selection.subjects(layout), source, selection.options)
+				if err != nil {
+					return err
+				}
+				var flagged []bool
+				if rule != nil {
+					flagged = flagOutliers(observations, rule)
+					fmt.Fprintf(os.Stderr, "leaving out %d of %d iterations that are outliers by %s\n", countFlags(flagged), len(flagged), rule)
+				}
+				model, err := fitMixedModel(selectTask(observations, taskFilter, flagged), formula)
+				if err != nil {
+					return err
+				}
+
+				w, err := layout.openOutput(out)
+				if err != nil {
+					return err
+				}
+				writeMixedModel(w, model, formula.group)
+				return w.Close()
+			}
+		},
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMixedFormula(t *testing.T) {
+	formula, err := parseMixedFormula("complete ~ speed*difficulty + oprange + (1|subject)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	terms := make([]string, len(formula.terms))
+	for index, term := range formula.terms {
+		terms[index] = strings.Join(term, ":")
+	}
+	if got, want := strings.Join(terms, " + "), "speed + difficulty + oprange + speed:difficulty"; got != want {
+		t.Errorf("terms are %s, expected %s", got, want)
+	}
+	if formula.response != "complete" || formula.group != "subject" {
+		t.Errorf("response %q and group %q, expected complete and subject", formula.response, formula.group)
+	}
+
+	for _, test := range []struct {
+		formula, err string
+	}{
+		{"complete speed + (1|subject)", "no ~"},
+		{"latency ~ speed + (1|subject)", "unknown measure"},
+		{"complete ~ speed + colour + (1|subject)", "unknown factor \"colour\""},
+		{"complete ~ speed*colour + (1|subject)", "unknown factor \"colour\""},
+		{"complete ~ speed + difficulty", "no random intercept"},
+		{"complete ~ speed + (speed|subject)", "only random intercepts"},
+		{"complete ~ speed + (1|subject) + (1|block)", "only one grouping factor"},
+		{"complete ~ speed + (1|colour)", "unknown factor \"colour\""},
+		{"complete ~ subject + (1|subject)", "both a fixed and a grouping factor"},
+	} {
+		_, err := parseMixedFormula(test.formula)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s gave error %v, expected one about %s", test.formula, err, test.err)
+		}
+	}
+}
+
+// a balanced design of 4 subjects with 3 iterations at each of 2 speeds. for balanced data lme4's lmer with
+// REML = TRUE gives the ANOVA estimates: the residual variance is the within-subject mean square after the speed
+// effect, the subject variance is (MS subject - residual) / 6, and the fixed effects are the cell means. the REML
+// criterion is -2 times the restricted log likelihood computed from the full covariance matrix at those estimates
+func TestFitMixedModel(t *testing.T) {
+	data := map[int]map[int][]float64{
+		1: {0: {4.1, 4.5, 3.9}, 200: {5.2, 5.0, 5.6}},
+		2: {0: {3.2, 3.6, 3.0}, 200: {4.4, 4.1, 4.9}},
+		3: {0: {5.0, 4.7, 5.3}, 200: {5.9, 6.3, 5.8}},
+		4: {0: {3.8, 4.2, 4.4}, 200: {4.6, 5.1, 4.7}},
+	}
+	observations := make([]Observation, 0)
+	for subject := 1; subject <= 4; subject++ {
+		for _, speed := range []int{0, 200} {
+			levels := &IVLevels{TargetNumber: 1, TargetSpeed: speed}
+			for _, complete := range data[subject][speed] {
+				observations = append(observations, Observation{Iteration: Iteration{Subject: subject, Levels: levels,
+					Complete: complete}, Type: "targeting"})
+			}
+		}
+	}
+	formula, err := parseMixedFormula("complete ~ speed + (1|subject)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := fitMixedModel(observations, formula)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(m.names, ", ") != "(Intercept), speed200" || m.observations != 24 || m.groups != 4 {
+		t.Fatalf("fitted %v to %d observations in %d groups", m.names, m.observations, m.groups)
+	}
+	checkClose(t, "intercept", m.estimates[0], 4.141666666666667, 1e-6)
+	checkClose(t, "intercept se", m.se[0], 0.3440253416138331, 1e-6)
+	checkClose(t, "speed200", m.estimates[1], 0.9916666666666663, 1e-6)
+	checkClose(t, "speed200 se", m.se[1], 0.12476586259003838, 1e-6)
+	checkClose(t, "subject variance", m.groupVariance, 0.4422807017543855, 1e-6)
+	checkClose(t, "residual variance", m.residualVariance, 0.09339912280701805, 1e-6)
+	checkClose(t, "REML criterion", m.reml, 25.38813480290738, 1e-6)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Observation is an iteration with the measures analysis.R derives from r1.txt
+type Observation struct {
+	Iteration
+	// human or model, from how the trial was recorded
+	Source string
+	// addition, targeting or main for the dual task, as analysis.R's assembleData splits blocks
+	Type string
+	// shots that hit nothing
+	Misses int
+	// the fraction of shots that hit an enemy, NaN without shots
+	Accuracy float64
+	// how much the two tasks of a dual task iteration overlapped, from 0 for one after the other to 1 for
+	// completely at once given the subject's single task times. NaN for single task iterations
+	Concurrency float64
+	// the subject's entry in the registry, or nil if there is none
+	Info *SubjectInfo
+}
+
+// the measures of an observation that can be summarised, in the order of r1.txt
+var observationMeasures = []string{"addition", "target", "complete", "hits", "friendHits", "shots", "hovers", "op1", "op2",
+	"misses", "accuracy", "concurrency"}
+
+// get a variable of an observation by name. measures that do not apply to its type are NaN, as analysis.R has them NA
+func (o Observation) field(name string) interface{} {
+	switch name {
+	case "source":
+		return o.Source
+	case "type":
+		return o.Type
+	case "misses":
+		return float64(o.Misses)
+	case "accuracy":
+		return o.Accuracy
+	case "concurrency":
+		return o.Concurrency
+	case "gender", "age", "handedness", "session":
+		if o.Info == nil || o.Info.column(name) == "" {
+			return "NA"
+		}
+		return o.Info.column(name)
+	case "addition":
+		if o.Type == "targeting" {
+			return math.NaN()
+		}
+	case "target", "hits", "friendHits", "shots":
+		if o.Type == "addition" {
+			return math.NaN()
+		}
+	}
+	return o.Iteration.field(name)
+}
+
+// get a measure of an observation by name
+func (o Observation) measure(name string) float64 {
+	value, _ := o.field(name).(float64)
+	return value
+}
+
+// test if a name is a measure of observations
+func isMeasure(name string) bool {
+	for _, measure := range observationMeasures {
+		if measure == name {
+			return true
+		}
+	}
+	return false
+}
+
+// get the type of task of a block: addition or targeting alone, or main for both at once
+func taskType(levels *IVLevels) string {
+	if levels.TargetNumber == 0 {
+		return "addition"
+	}
+	if len(levels.AdditionDifficulty) == 0 {
+		return "targeting"
+	}
+	return "main"
+}
+
+// tell whether a trial was recorded from a person or ingested from a model run. model runs have the manifest
+// written by ingest-model, or the trace.txt that logToData.sh copied before there were manifests
+func trialSource(layout *Layout, subject int, block, trial string) string {
+	dir := layout.TrialDir(subject, block, trial)
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json")); err == nil {
+		var manifest IngestManifest
+		if json.Unmarshal(data, &manifest) == nil && len(manifest.Files) > 0 {
+			return "model"
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "trace.txt")); err == nil {
+		return "model"
+	}
+	return "human"
+}
+
+// the single task condition a dual task iteration is compared with
+type singleTaskKey struct {
+	subject int
+	source  string
+	task    string
+	level   string
+}
+
+// fill in the concurrency of the dual task observations of each subject and source from the mean completion
+// times of the matching addition and targeting blocks, as assembleData did. practice iterations are not used
+func computeConcurrency(observations []Observation) {
+	completes := make(map[singleTaskKey][]float64)
+	for _, o := range observations {
+		if o.Levels.Practice {
+			continue
+		}
+		switch o.Type {
+		case "addition":
+			key := singleTaskKey{o.Subject, o.Source, "addition", opRangeLevel(o.Levels)}
+			completes[key] = append(completes[key], o.Complete)
+		case "targeting":
+			key := singleTaskKey{o.Subject, o.Source, "targeting", fmt.Sprint(o.Levels.TargetSpeed, o.Levels.TargetDifficulty)}
+			completes[key] = append(completes[key], o.Complete)
+		}
+	}
+	means := make(map[singleTaskKey]float64)
+	for key, values := range completes {
+		means[key], _ = mean(values)
+	}
+	for index := range observations {
+		o := &observations[index]
+		o.Concurrency = math.NaN()
+		if o.Type != "main" || o.Levels.Practice {
+			continue
+		}
+		addition, okAddition := means[singleTaskKey{o.Subject, o.Source, "addition", opRangeLevel(o.Levels)}]
+		target, okTarget := means[singleTaskKey{o.Subject, o.Source, "targeting", fmt.Sprint(o.Levels.TargetSpeed, o.Levels.TargetDifficulty)}]
+		if okAddition && okTarget {
+			sequential := addition + target
+			o.Concurrency = (o.Complete - sequential) / (math.Max(addition, target) - sequential)
+		}
+	}
+}
+
+// derive the measures of an iteration. its concurrency is set by computeConcurrency
+func newObservation(it Iteration, source string) Observation {
+	o := Observation{Iteration: it, Source: source, Type: taskType(it.Levels),
+		Misses: it.Shots - it.Hits - it.FriendHits, Accuracy: math.NaN(), Concurrency: math.NaN()}
+	if it.Shots > 0 {
+		o.Accuracy = float64(it.Hits) / float64(it.Shots)
+	}
+	return o
+}
+
+// derive the measures of aggregated iterations
+func makeObservations(layout *Layout, iterations []Iteration) []Observation {
+	observations := make([]Observation, len(iterations))
+	sources := make(map[string]string)
+	for index, it := range iterations {
+		dir := layout.TrialDir(it.Subject, it.Block, it.Trial)
+		source, ok := sources[dir]
+		if !ok {
+			source = trialSource(layout, it.Subject, it.Block, it.Trial)
+			sources[dir] = source
+		}
+		observations[index] = newObservation(it, source)
+		observations[index].Info = layout.registry[it.Subject]
+	}
+	computeConcurrency(observations)
+	return observations
+}
+
+// parse the trials of subjects into observations. if source is set only the trials from that source are parsed.
+// subjects the registry excludes are left out. trials that cannot be parsed are reported and make it fail
+func loadObservations(layout *Layout, subjects []int, source string, options aggregateOptions) ([]Observation, error) {
+	subjects = layout.includedSubjects(subjects)
+	jobs := make([]trialJob, 0)
+	sources := make([]string, 0)
+	ignored := 0
+	for _, subject := range subjects {
+		for _, job := range subjectJobs(layout, subject, options) {
+			jobSource := trialSource(layout, job.subject, job.block, job.trial)
+			if source != "" && jobSource != source {
+				ignored++
+				continue
+			}
+			jobs = append(jobs, job)
+			sources = append(sources, jobSource)
+		}
+	}
+	if ignored > 0 {
+		fmt.Fprintf(os.Stderr, "ignoring %d trials in %s that are not %s trials\n", ignored, layout.Root, source)
+	}
+	if len(jobs) == 0 && source != "" {
+		return nil, fmt.Errorf("no %s trials found in %s", source, layout.Root)
+	}
+
+	observations := make([]Observation, 0)
+	failed := 0
+	for index, result := range runJobs(layout, jobs, options) {
+		if result.err != nil {
+			fmt.Fprintln(os.Stderr, result.err)
+			failed++
+			continue
+		}
+		for _, it := range result.iterations {
+			o := newObservation(it, sources[index])
+			o.Info = layout.registry[it.Subject]
+			observations = append(observations, o)
+		}
+	}
+	if failed > 0 {
+		return nil, fmt.Errorf("%d trials of %s could not be parsed", failed, layout.Root)
+	}
+	computeConcurrency(observations)
+	return observations, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// outlierRule decides which iterations are outliers among those of a subject under a condition
+type outlierRule struct {
+	// sd, iqr or mad
+	method string
+	// how many standard deviations, interquartile ranges or scaled median absolute deviations from the centre is too far
+	cutoff float64
+	// the measure that is tested
+	measure string
+}
+
+// the cutoffs used when a rule does not give one
+var defaultOutlierCutoffs = map[string]float64{"sd": 3, "iqr": 1.5, "mad": 3}
+
+// parse a rule such as "iqr", "sd:2.5" or "mad:3" for a measure
+func parseOutlierRule(spec, measure string) (*outlierRule, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	cutoff, ok := defaultOutlierCutoffs[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown outlier rule %q, expected sd, iqr or mad", parts[0])
+	}
+	if len(parts) == 2 {
+		var err error
+		if cutoff, err = strconv.ParseFloat(parts[1], 64); err != nil || cutoff <= 0 {
+			return nil, fmt.Errorf("bad outlier cutoff %q", parts[1])
+		}
+	}
+	if !isMeasure(measure) {
+		return nil, fmt.Errorf("unknown measure %q", measure)
+	}
+	return &outlierRule{parts[0], cutoff, measure}, nil
+}
+
+// describe a rule as it is recorded in result files
+func (r *outlierRule) String() string {
+	return fmt.Sprintf("%s %s:%s", r.measure, r.method, strconv.FormatFloat(r.cutoff, 'f', -1, 64))
+}
+
+// the range of values of a group that are not outliers
+func (r *outlierRule) bounds(values []float64) (float64, float64) {
+	sorted := sortedValues(values)
+	switch r.method {
+	case "sd":
+		m, _ := mean(sorted)
+		spread := r.cutoff * sd(sorted)
+		return m - spread, m + spread
+	case "iqr":
+		// the fences of boxplot.stats, which removeOutliers used
+		lower, upper := hinges(sorted)
+		spread := r.cutoff * (upper - lower)
+		return lower - spread, upper + spread
+	}
+	// the median absolute deviation scaled to match the standard deviation of normal data, as R's mad
+	median := quantile(sorted, 0.5)
+	deviations := make([]float64, len(sorted))
+	for index, value := range sorted {
+		deviations[index] = math.Abs(value - median)
+	}
+	spread := r.cutoff * 1.4826 * quantile(sortedValues(deviations), 0.5)
+	return median - spread, median + spread
+}
+
+// flag the observations that are outliers among those of the same subject, source and condition.
+// observations without a value of the measure are never outliers
+func flagOutliers(observations []Observation, rule *outlierRule) []bool {
+	flags := make([]bool, len(observations))
+	groups := groupObservations(observations, []string{"subject", "source", "practice", "type", "speed", "difficulty", "oprange"})
+	for _, group := range groups {
+		values := make([]float64, len(group.Members))
+		for i, index := range group.Members {
+			values[i] = observations[index].measure(rule.measure)
+		}
+		low, high := rule.bounds(values)
+		for i, index := range group.Members {
+			flags[index] = values[i] < low || values[i] > high
+		}
+	}
+	return flags
+}
+
+// count the flagged observations
+func countFlags(flags []bool) int {
+	n := 0
+	for _, flag := range flags {
+		if flag {
+			n++
+		}
+	}
+	return n
+}
+
+// flags choosing an outlier rule
+type outlierFlags struct {
+	spec    string
+	measure string
+}
+
+func addOutlierFlags(flags *flag.FlagSet) *outlierFlags {
+	o := new(outlierFlags)
+	flags.StringVar(&o.spec, "outliers", "", "Flag outliers of each subject and condition by a rule: sd, iqr or mad, with an optional cutoff, e.g. iqr:1.5")
+	flags.StringVar(&o.measure, "outlier-measure", "complete", "The measure the outlier rule tests")
+	return o
+}
+
+// get the rule chosen by the flags, or nil if outliers are not flagged
+func (o *outlierFlags) rule() (*outlierRule, error) {
+	if o.spec == "" {
+		return nil, nil
+	}
+	return parseOutlierRule(o.spec, o.measure)
+}
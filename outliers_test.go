@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseOutlierRule(t *testing.T) {
+	for _, test := range []struct {
+		spec, measure, rule, err string
+	}{
+		{"iqr", "complete", "complete iqr:1.5", ""},
+		{"sd", "target", "target sd:3", ""},
+		{"mad:2.5", "complete", "complete mad:2.5", ""},
+		{"median", "complete", "", `unknown outlier rule "median"`},
+		{"sd:0", "complete", "", `bad outlier cutoff "0"`},
+		{"sd:x", "complete", "", `bad outlier cutoff "x"`},
+		{"sd:2", "speed", "", `unknown measure "speed"`},
+	} {
+		rule, err := parseOutlierRule(test.spec, test.measure)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v and error %v, expected an error with %s", test.spec, rule, err, test.err)
+			}
+		} else if err != nil || rule.String() != test.rule {
+			t.Errorf("%s: got %v and error %v, expected %s", test.spec, rule, err, test.rule)
+		}
+	}
+}
+
+func TestOutlierBounds(t *testing.T) {
+	// 1 to 9 and 100, with a NaN that is left out
+	values := []float64{100, 9, 8, 7, 6, 5, 4, 3, 2, 1, math.NaN()}
+	sd := math.Sqrt(8182.5 / 9)
+	for _, test := range []struct {
+		rule         outlierRule
+		lower, upper float64
+	}{
+		// the mean is 14.5 and the squared deviations sum to 8182.5
+		{outlierRule{"sd", 3, "complete"}, 14.5 - 3*sd, 14.5 + 3*sd},
+		{outlierRule{"sd", 2, "complete"}, 14.5 - 2*sd, 14.5 + 2*sd},
+		// boxplot.stats gives the hinges 3 and 8
+		{outlierRule{"iqr", 1.5, "complete"}, 3 - 7.5, 8 + 7.5},
+		// the median is 5.5 and the median absolute deviation 2.5, so mad() is 3.7065
+		{outlierRule{"mad", 3, "complete"}, 5.5 - 3*3.7065, 5.5 + 3*3.7065},
+	} {
+		lower, upper := test.rule.bounds(values)
+		checkClose(t, test.rule.String()+" lower", lower, test.lower, 1e-12)
+		checkClose(t, test.rule.String()+" upper", upper, test.upper, 1e-12)
+	}
+}
+
+func TestFlagOutliers(t *testing.T) {
+	levels := &IVLevels{TargetNumber: 1}
+	observations := make([]Observation, 0)
+	add := func(subject int, complete float64) {
+		observations = append(observations, Observation{Iteration: Iteration{Subject: subject, Levels: levels, Complete: complete}})
+	}
+	for _, value := range []float64{100, 9, 8, 7, 6, 5, 4, 3, 2, 1, math.NaN()} {
+		add(1, value)
+	}
+	// 100 is not far from the other values of subject 2
+	for _, value := range []float64{100, 101, 102, 103} {
+		add(2, value)
+	}
+	for spec, want := range map[string]int{"iqr": 1, "mad": 1, "sd:2": 1, "sd": 0} {
+		rule, err := parseOutlierRule(spec, "complete")
+		if err != nil {
+			t.Fatal(err)
+		}
+		flags := flagOutliers(observations, rule)
+		if countFlags(flags) != want || want == 1 && !flags[0] {
+			t.Errorf("%s flagged %v, expected %d", spec, flags, want)
+		}
+	}
+}
@@ -1,5 +0,0 @@
-#!/bin/bash
-root=/Volumes/cjbest
-# root=~/Google\ Drive
-# root=~/Desktop/unision-transfer
-cp output/subject$2/block$1/trial0/QN_ACTR_Model_Initialization.txt "${root}/Research/temp"
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the number of digits of the millisecond time stamps in data.txt
+const stampDigits = 13
+
+// the model log has the time in seconds as the second field of each event, as in "TargetHit, 12.345, ..."
+var modelLogLineRE = regexp.MustCompile(`^([^,]*, )([^,\n]*)(.*)$`)
+
+// a plain decimal number of seconds, which is converted without going through floating point
+var plainSecondsRE = regexp.MustCompile(`^(\d+)(?:\.(\d*))?$`)
+
+// convert a number of seconds to a zero padded millisecond time stamp, truncating fractions of a millisecond.
+// plain decimals are converted exactly, so 1.001 becomes 1001 where clockToStamp.pl's int($2*1000) gave 1000
+func secondsToStamp(field string) (string, error) {
+	field = strings.TrimSpace(field)
+	seconds, err := strconv.ParseFloat(field, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return "", fmt.Errorf("time %q is not a number", field)
+	}
+	// a sign is checked as well as the value, so that negative zero is not let through
+	if seconds < 0 || strings.HasPrefix(field, "-") {
+		return "", fmt.Errorf("time %q is negative", field)
+	}
+
+	var ms string
+	if match := plainSecondsRE.FindStringSubmatch(field); match != nil {
+		fraction := (match[2] + "000")[:3]
+		ms = strings.TrimLeft(match[1]+fraction, "0")
+	} else {
+		// exponents and the like
+		ms = strconv.FormatFloat(math.Floor(seconds*1000), 'f', 0, 64)
+		ms = strings.TrimLeft(ms, "0")
+	}
+	if len(ms) > stampDigits {
+		return "", fmt.Errorf("time %q does not fit in %d digits of milliseconds", field, stampDigits)
+	}
+	return strings.Repeat("0", stampDigits-len(ms)) + ms, nil
+}
+
+// convert the times of a model log from seconds to the millisecond time stamps of data.txt.
+// lines without a second field are passed through unchanged, as clockToStamp.pl did
+func stampModelLog(r io.Reader) ([]string, error) {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Text()
+		if match := modelLogLineRE.FindStringSubmatch(line); match != nil {
+			stamp, err := secondsToStamp(match[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", number, err)
+			}
+			line = match[1] + stamp + match[3]
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// write lines to a file, or stdout for "-"
+func writeLines(path string, lines []string) error {
+	var w io.WriteCloser = nopWriteCloser{os.Stdout}
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		w = file
+	}
+	buffered := bufio.NewWriter(w)
+	for _, line := range lines {
+		fmt.Fprintln(buffered, line)
+	}
+	err := buffered.Flush()
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func init() {
+	addCommand(&command{
+		name:  "stamp",
+		args:  "[log file]",
+		short: "convert a model log from seconds to data.txt time stamps",
+		long: `Stamp converts the time in the second field of each event of a model log (log.txt by
+default) from seconds to the zero padded millisecond time stamps of data.txt, as clockToStamp.pl
+did. It stops at the first time that is not a number, is negative or needs more than 13 digits.
+With -normalised the events are written with times in seconds since the trial start instead,
+as the aggregator reads them.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			var out string
+			var normalised bool
+			flags.StringVar(&out, "out", "-", "The file to write, or - for stdout")
+			flags.BoolVar(&normalised, "normalised", false, "Write times in seconds since the trial start instead of time stamps")
+			return func(args []string) error {
+				path := "log.txt"
+				if len(args) > 0 {
+					path = args[0]
+				}
+				file, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				lines, err := stampModelLog(file)
+				file.Close()
+				if err != nil {
+					return fmt.Errorf("%s: %v", path, err)
+				}
+				if normalised {
+					if lines, err = normaliseEvents(strings.Join(lines, "\n")); err != nil {
+						return fmt.Errorf("%s: %v", path, err)
+					}
+				}
+				return writeLines(out, lines)
+			}
+		},
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSecondsToStamp(t *testing.T) {
+	for _, test := range []struct {
+		field, stamp, err string
+	}{
+		{"0", "0000000000000", ""},
+		{" 1.5 ", "0000000001500", ""},
+		{"1.001", "0000000001001", ""},
+		{"2.0009", "0000000002000", ""},
+		{"12.", "0000000012000", ""},
+		{"1e3", "0000001000000", ""},
+		{"+1", "0000000001000", ""},
+		{"-1", "", "negative"},
+		{"-0", "", "negative"},
+		{"-0.0", "", "negative"},
+		{"-0e0", "", "negative"},
+		{"abc", "", "not a number"},
+		{"NaN", "", "not a number"},
+		{"Inf", "", "not a number"},
+		{"10000000000", "", "does not fit"},
+	} {
+		stamp, err := secondsToStamp(test.field)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: got %q and error %v, expected an error with %q", test.field, stamp, err, test.err)
+			}
+		} else if err != nil || stamp != test.stamp {
+			t.Errorf("%q: got %q and error %v, expected %q", test.field, stamp, err, test.stamp)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// play the model's role for a single run: take the input from the drop folder and, after a delay,
+// put copies of the files of a replay directory there as the run's output
+func standInRun(drop, replay string, delay time.Duration) error {
+	if err := os.Remove(filepath.Join(drop, modelInitFile)); err != nil {
+		return err
+	}
+	time.Sleep(delay)
+	infos, err := ioutil.ReadDir(replay)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(replay, info.Name()))
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(drop, info.Name()), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	addCommand(&command{
+		name:  "model-standin",
+		args:  "<drop folder>",
+		short: "stand in for the model when testing batch",
+		long: `Model-standin watches a drop folder as the model does. Whenever a
+QN_ACTR_Model_Initialization.txt appears it removes it and, after -delay, copies the files
+of the -replay directory (such as the log.txt, trace.txt and utilization results of a real
+run) into the folder as the run's output. It stops after -runs runs, or never if -runs is 0.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			var replay string
+			var runs int
+			var poll, delay time.Duration
+			flags.StringVar(&replay, "replay", "", "The directory of output files to hand back for each run")
+			flags.IntVar(&runs, "runs", 0, "The number of runs to make before stopping, or 0 to run forever")
+			flags.DurationVar(&poll, "poll", time.Second, "How often to check for model input")
+			flags.DurationVar(&delay, "delay", 0, "How long each run takes")
+			return func(args []string) error {
+				if len(args) != 1 {
+					return fmt.Errorf("expected one drop folder")
+				}
+				if replay == "" {
+					return fmt.Errorf("-replay is required")
+				}
+				drop := args[0]
+				for run := 1; runs == 0 || run <= runs; {
+					if _, err := os.Stat(filepath.Join(drop, modelInitFile)); err != nil {
+						time.Sleep(poll)
+						continue
+					}
+					fmt.Printf("run %d\n", run)
+					if err := standInRun(drop, replay, delay); err != nil {
+						return err
+					}
+					run++
+				}
+				return nil
+			}
+		},
+	})
+}
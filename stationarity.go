@@ -0,0 +1,296 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// linear interpolation of y at x between points with increasing xs, constant outside them as R's approx with rule = 2
+func interpolate(xs, ys []float64, x float64) float64 {
+	if x <= xs[0] {
+		return ys[0]
+	}
+	for index := 1; index < len(xs); index++ {
+		if x <= xs[index] {
+			return ys[index-1] + (x-xs[index-1])/(xs[index]-xs[index-1])*(ys[index]-ys[index-1])
+		}
+	}
+	return ys[len(ys)-1]
+}
+
+// fit y on the columns of x by least squares, returning the coefficients and their standard errors
+func leastSquares(x [][]float64, y []float64) ([]float64, []float64, bool) {
+	p := len(x[0])
+	xtx := make([][]float64, p)
+	xty := make([]float64, p)
+	for i := range xtx {
+		xtx[i] = make([]float64, p)
+		for row := range x {
+			for j := range xtx[i] {
+				xtx[i][j] += x[row][i] * x[row][j]
+			}
+			xty[i] += x[row][i] * y[row]
+		}
+	}
+	l, ok := cholesky(xtx)
+	if !ok || len(y) <= p {
+		return nil, nil, false
+	}
+	beta := choleskySolve(l, xty)
+	residuals := 0.0
+	for row := range x {
+		fitted := 0.0
+		for j := range beta {
+			fitted += x[row][j] * beta[j]
+		}
+		residuals += (y[row] - fitted) * (y[row] - fitted)
+	}
+	variance := residuals / float64(len(y)-p)
+	se := make([]float64, p)
+	for i := range se {
+		unit := make([]float64, p)
+		unit[i] = 1
+		se[i] = math.Sqrt(variance * choleskySolve(l, unit)[i])
+	}
+	return beta, se, true
+}
+
+// the critical values of the augmented Dickey-Fuller test with a constant and trend for series of the lengths in
+// adfLengths, at the probabilities of adfProbabilities, as tseries' adf.test has them from Banerjee et al. (1993)
+var (
+	adfLengths       = []float64{25, 50, 100, 250, 500, 1e5}
+	adfProbabilities = []float64{0.01, 0.025, 0.05, 0.1, 0.9, 0.95, 0.975, 0.99}
+	adfTable         = [][]float64{
+		{-4.38, -4.15, -4.04, -3.99, -3.98, -3.96},
+		{-3.95, -3.8, -3.73, -3.69, -3.68, -3.66},
+		{-3.6, -3.5, -3.45, -3.43, -3.42, -3.41},
+		{-3.24, -3.18, -3.15, -3.13, -3.13, -3.12},
+		{-1.14, -1.19, -1.22, -1.23, -1.24, -1.25},
+		{-0.8, -0.87, -0.9, -0.92, -0.93, -0.94},
+		{-0.5, -0.58, -0.62, -0.64, -0.65, -0.66},
+		{-0.15, -0.24, -0.28, -0.31, -0.32, -0.33},
+	}
+)
+
+// the augmented Dickey-Fuller test of a unit root against a stationary series with a trend, as tseries' adf.test
+// with its default lag order trunc((n-1)^(1/3)). small p values mean the series is stationary. p values outside
+// the table are clamped to 0.01 or 0.99
+func adfTest(x []float64) (statistic float64, lag int, p float64) {
+	lag = int(math.Trunc(math.Pow(float64(len(x)-1), 1.0/3)))
+	k := lag + 1
+	y := make([]float64, len(x)-1)
+	for index := range y {
+		y[index] = x[index+1] - x[index]
+	}
+	n := len(y)
+	design := make([][]float64, 0, n-k+1)
+	response := make([]float64, 0, n-k+1)
+	for t := k - 1; t < n; t++ {
+		row := []float64{1, x[t], float64(t + 1)}
+		for l := 1; l < k; l++ {
+			row = append(row, y[t-l])
+		}
+		design = append(design, row)
+		response = append(response, y[t])
+	}
+	if len(response) == 0 {
+		return math.NaN(), lag, math.NaN()
+	}
+	beta, se, ok := leastSquares(design, response)
+	if !ok {
+		return math.NaN(), lag, math.NaN()
+	}
+	statistic = beta[1] / se[1]
+	critical := make([]float64, len(adfTable))
+	for index, row := range adfTable {
+		critical[index] = interpolate(adfLengths, row, float64(n))
+	}
+	return statistic, lag, interpolate(critical, adfProbabilities, statistic)
+}
+
+// the critical values of the KPSS test for level and trend stationarity, as tseries' kpss.test has them
+var kpssCritical = map[string][]float64{
+	"level": {0.347, 0.463, 0.574, 0.739},
+	"trend": {0.119, 0.146, 0.176, 0.216},
+}
+var kpssProbabilities = []float64{0.1, 0.05, 0.025, 0.01}
+
+// the KPSS test of level or trend stationarity against a unit root, as tseries' kpss.test with its short lag
+// truncation trunc(4(n/100)^(1/4)). small p values mean the series is not stationary. p values outside the
+// table are clamped to 0.01 or 0.1
+func kpssTest(x []float64, null string) (statistic float64, lag int, p float64) {
+	n := len(x)
+	residuals := make([]float64, n)
+	if null == "trend" {
+		design := make([][]float64, n)
+		for t := range design {
+			design[t] = []float64{1, float64(t + 1)}
+		}
+		beta, _, ok := leastSquares(design, x)
+		if !ok {
+			return math.NaN(), 0, math.NaN()
+		}
+		for t := range residuals {
+			residuals[t] = x[t] - beta[0] - beta[1]*float64(t+1)
+		}
+	} else {
+		m, _ := mean(x)
+		for t := range residuals {
+			residuals[t] = x[t] - m
+		}
+	}
+	eta, sum, variance := 0.0, 0.0, 0.0
+	for _, e := range residuals {
+		sum += e
+		eta += sum * sum
+		variance += e * e
+	}
+	eta /= float64(n * n)
+	variance /= float64(n)
+	// the Newey-West estimate of the long run variance with Bartlett weights
+	lag = int(math.Trunc(4 * math.Pow(float64(n)/100, 0.25)))
+	for i := 1; i <= lag; i++ {
+		autocovariance := 0.0
+		for j := i; j < n; j++ {
+			autocovariance += residuals[j] * residuals[j-i]
+		}
+		variance += 2 * (1 - float64(i)/float64(lag+1)) * autocovariance / float64(n)
+	}
+	statistic = eta / variance
+	return statistic, lag, interpolate(kpssCritical[null], kpssProbabilities, statistic)
+}
+
+// the tests of a window of a subject's series
+type stationarityWindow struct {
+	Subject    int
+	Window     int
+	Start, End int
+	// whole for the window, or part for the window after the offset
+	Part    string
+	ADF     float64
+	ADFLag  int
+	ADFP    float64
+	KPSS    float64
+	KPSSLag int
+	KPSSP   float64
+}
+
+// test windows of the series of each subject as adf.learn.R did: each window of length values, then the same
+// window without its first offset values
+func stationarityWindows(observations []Observation, measure string, length, offset int, null string) []stationarityWindow {
+	windows := make([]stationarityWindow, 0)
+	for _, group := range groupObservations(observations, []string{"subject"}) {
+		members := group.Members
+		sort.SliceStable(members, func(i, j int) bool { return doneBefore(observations[members[i]], observations[members[j]]) })
+		series := make([]float64, 0, len(members))
+		for _, index := range members {
+			if value := observations[index].measure(measure); !math.IsNaN(value) {
+				series = append(series, value)
+			}
+		}
+		subject := observations[members[0]].Subject
+		for window := 0; (window+1)*length <= len(series); window++ {
+			end := (window + 1) * length
+			for _, part := range []struct {
+				name  string
+				start int
+			}{{"whole", window * length}, {"part", window*length + offset}} {
+				values := series[part.start:end]
+				w := stationarityWindow{Subject: subject, Window: window, Start: part.start, End: end, Part: part.name}
+				w.ADF, w.ADFLag, w.ADFP = adfTest(values)
+				w.KPSS, w.KPSSLag, w.KPSSP = kpssTest(values, null)
+				windows = append(windows, w)
+			}
+		}
+	}
+	return windows
+}
+
+func writeStationarity(w io.Writer, windows []stationarityWindow) {
+	fmt.Fprintln(w, "subject, window, start, end, part, adf, adfLag, adfP, kpss, kpssLag, kpssP")
+	for _, s := range windows {
+		fmt.Fprintf(w, "%d, %d, %d, %d, %s, %s, %d, %s, %s, %d, %s\n", s.Subject, s.Window, s.Start, s.End, s.Part,
+			formatStat(s.ADF), s.ADFLag, formatStat(s.ADFP), formatStat(s.KPSS), s.KPSSLag, formatStat(s.KPSSP))
+	}
+}
+
+func init() {
+	addCommand(&command{
+		name:  "stationarity",
+		short: "test windows of each subject's iterations for drift",
+		long: `Stationarity puts the iterations of each subject in the order they were done and, as
+adf.learn.R did, splits the values of a measure into windows of -length values. Each window is
+tested whole and again without its first -offset values, so windows of 36 with an offset of 12
+test three blocks of 12 iterations with and without the first.
+
+Each test writes the augmented Dickey-Fuller statistic, lag order and p value, where a small p
+value means the window is stationary, and the KPSS statistic, lag and p value, where a small p
+value means it is not. Both follow tseries' adf.test and kpss.test, including their tables of
+p values, which are clamped to the ends of the tables. -kpss-null chooses level or trend
+stationarity as the null hypothesis of the KPSS test. Values left over after the last whole
+window are not tested.
+
+Practice blocks are left out unless -practice is given, and -type chooses the type of task
+(addition, targeting or main, or all for every type).`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			selection := addAnalysisSelectionFlags(flags)
+			caching := addCacheFlags(flags)
+			var measure, taskFilter, null, where, source, out string
+			var length, offset int
+			var practice bool
+			flags.StringVar(&measure, "measure", "complete", "The measure to test")
+			flags.IntVar(&length, "length", 36, "The number of values in a window")
+			flags.IntVar(&offset, "offset", 12, "The number of values at the start of a window to leave out of the second test")
+			flags.StringVar(&null, "kpss-null", "level", "The null hypothesis of the KPSS test: level or trend")
+			flags.StringVar(&taskFilter, "type", "all", "The type of task to test: addition, targeting, main or all")
+			flags.BoolVar(&practice, "practice", false, "Test practice blocks too")
+			flags.StringVar(&where, "where", "", "Only use the iterations for which an expression is true")
+			flags.StringVar(&source, "source", "", "Only use trials from this source, human or model")
+			flags.StringVar(&out, "out", "-", "The file to write, or - for stdout")
+			return func(args []string) error {
+				if !isMeasure(measure) {
+					return fmt.Errorf("unknown measure %q", measure)
+				}
+				if _, ok := kpssCritical[null]; !ok {
+					return fmt.Errorf("unknown KPSS null hypothesis %q, expected level or trend", null)
+				}
+				if offset < 0 || length-offset < 12 {
+					return fmt.Errorf("windows need at least 12 values after the offset")
+				}
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				if where != "" {
+					if selection.options.where, err = parseFilter(where); err != nil {
+						return err
+					}
+				}
+				if selection.options.cache, err = caching.cache(layout); err != nil {
+					return err
+				}
+				observations, err := loadObservations(layout, selection.subjects(layout), source, selection.options)
+				if err != nil {
+					return err
+				}
+				kept := make([]Observation, 0, len(observations))
+				for _, o := range observations {
+					if (practice || !o.Levels.Practice) && (taskFilter == "all" || o.Type == taskFilter) {
+						kept = append(kept, o)
+					}
+				}
+
+				w, err := layout.openOutput(out)
+				if err != nil {
+					return err
+				}
+				writeStationarity(w, stationarityWindows(kept, measure, length, offset, null))
+				return w.Close()
+			}
+		},
+	})
+}
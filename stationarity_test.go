@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+// an AR(1) series with a coefficient of 0.9 and a small drift, from a fixed linear congruential generator
+func testSeries(n int) []float64 {
+	x := make([]float64, n)
+	state := int64(12345)
+	level := 0.0
+	for t := range x {
+		state = (1103515245*state + 12345) % 2147483648
+		level = 0.9*level + float64(state)/2147483648 - 0.5
+		x[t] = 0.01*float64(t) + level
+	}
+	return x
+}
+
+func TestInterpolate(t *testing.T) {
+	xs, ys := []float64{1, 2, 4}, []float64{10, 20, 0}
+	for _, test := range []struct{ x, want float64 }{
+		{0, 10}, {1, 10}, {1.5, 15}, {3, 10}, {4, 0}, {9, 0},
+	} {
+		checkClose(t, "interpolate", interpolate(xs, ys, test.x), test.want, 1e-12)
+	}
+	// a statistic at a critical value has its probability
+	checkClose(t, "the p value of the 5% critical value", interpolate(kpssCritical["level"], kpssProbabilities, 0.463), 0.05, 1e-12)
+}
+
+// the expected values are from a port of tseries' adf.test and kpss.test, with lm's least squares done by
+// Gauss-Jordan elimination
+func TestADF(t *testing.T) {
+	statistic, lag, p := adfTest(testSeries(60))
+	if lag != 3 {
+		t.Errorf("lag %d, expected trunc(59^(1/3)) = 3", lag)
+	}
+	checkClose(t, "ADF statistic", statistic, -2.4151365161396603, 1e-9)
+	checkClose(t, "ADF p", p, 0.40697796437362166, 1e-9)
+
+	// a random walk has a unit root, so its p value is clamped to the end of the table
+	walk := testSeries(150)
+	for index := 1; index < len(walk); index++ {
+		walk[index] += walk[index-1]
+	}
+	if _, lag, p := adfTest(walk); lag != 5 || p != 0.99 {
+		t.Errorf("a random walk has lag %d and p %g, expected 5 and 0.99", lag, p)
+	}
+}
+
+func TestKPSS(t *testing.T) {
+	x := testSeries(60)
+	statistic, lag, p := kpssTest(x, "level")
+	if lag != 3 {
+		t.Errorf("lag %d, expected trunc(4 (60/100)^(1/4)) = 3", lag)
+	}
+	checkClose(t, "KPSS level statistic", statistic, 0.45337215303154627, 1e-9)
+	checkClose(t, "KPSS level p", p, 0.054149934038126624, 1e-9)
+
+	statistic, lag, p = kpssTest(x, "trend")
+	if lag != 3 {
+		t.Errorf("lag %d, expected 3", lag)
+	}
+	checkClose(t, "KPSS trend statistic", statistic, 0.10943934978043736, 1e-9)
+	checkClose(t, "KPSS trend p", p, 0.1, 1e-12)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// the mean of the values that are not NaN, and how many there were
+func mean(values []float64) (float64, int) {
+	sum := 0.0
+	n := 0
+	for _, value := range values {
+		if !math.IsNaN(value) {
+			sum += value
+			n++
+		}
+	}
+	if n == 0 {
+		return math.NaN(), 0
+	}
+	return sum / float64(n), n
+}
+
+// the sample standard deviation of the values that are not NaN
+func sd(values []float64) float64 {
+	m, n := mean(values)
+	if n < 2 {
+		return math.NaN()
+	}
+	sum := 0.0
+	for _, value := range values {
+		if !math.IsNaN(value) {
+			sum += (value - m) * (value - m)
+		}
+	}
+	return math.Sqrt(sum / float64(n-1))
+}
+
+// the values that are not NaN, sorted
+func sortedValues(values []float64) []float64 {
+	sorted := make([]float64, 0, len(values))
+	for _, value := range values {
+		if !math.IsNaN(value) {
+			sorted = append(sorted, value)
+		}
+	}
+	sort.Float64s(sorted)
+	return sorted
+}
+
+// the p quantile of sorted values, interpolated as R's default quantile type 7
+func quantile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	h := float64(len(sorted)-1) * p
+	low := math.Floor(h)
+	high := math.Min(low+1, float64(len(sorted)-1))
+	return sorted[int(low)] + (h-low)*(sorted[int(high)]-sorted[int(low)])
+}
+
+// the lower and upper hinges of sorted values, as R's fivenum and boxplot.stats compute them
+func hinges(sorted []float64) (float64, float64) {
+	n := float64(len(sorted))
+	if n == 0 {
+		return math.NaN(), math.NaN()
+	}
+	n4 := math.Floor((n+3)/2) / 2
+	at := func(d float64) float64 {
+		return (sorted[int(math.Floor(d))-1] + sorted[int(math.Ceil(d))-1]) / 2
+	}
+	return at(n4), at(n + 1 - n4)
+}
+
+// the regularized incomplete beta function I_x(a, b), by the continued fraction of Numerical Recipes
+func incompleteBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	// the continued fraction converges quickly for x below the mean, so use the symmetry otherwise
+	if x > (a+1)/(a+b+2) {
+		return 1 - front*betaFraction(1-x, b, a)/b
+	}
+	return front * betaFraction(x, a, b) / a
+}
+
+func betaFraction(x, a, b float64) float64 {
+	const tiny = 1e-300
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= 300; m++ {
+		fm := float64(m)
+		for _, aa := range []float64{
+			fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1)),
+		} {
+			d = 1 + aa*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + aa/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+			if aa != 0 && math.Abs(d*c-1) < 1e-15 {
+				return h
+			}
+		}
+	}
+	return h
+}
+
+// the cumulative distribution function of Student's t distribution
+func tCDF(t, df float64) float64 {
+	tail := 0.5 * incompleteBeta(df/(df+t*t), df/2, 0.5)
+	if t > 0 {
+		return 1 - tail
+	}
+	return tail
+}
+
+// the two sided p value of a t statistic
+func tTest(t, df float64) float64 {
+	return incompleteBeta(df/(df+t*t), df/2, 0.5)
+}
+
+// the p quantile of Student's t distribution, as R's qt
+func tQuantile(p, df float64) float64 {
+	if df <= 0 || math.IsNaN(df) || p <= 0 || p >= 1 {
+		return math.NaN()
+	}
+	low, high := -1.0, 1.0
+	for tCDF(low, df) > p {
+		low *= 2
+	}
+	for tCDF(high, df) < p {
+		high *= 2
+	}
+	for i := 0; i < 200 && high-low > 1e-12; i++ {
+		middle := (low + high) / 2
+		if tCDF(middle, df) < p {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	return (low + high) / 2
+}
+
+// the upper tail probability of the F distribution
+func fTest(f, df1, df2 float64) float64 {
+	if f <= 0 {
+		return 1
+	}
+	return incompleteBeta(df2/(df2+df1*f), df2/2, df1/2)
+}
+
+// the Pearson correlation of pairs of values, skipping pairs with a NaN, with its two sided p value and the number of pairs.
+// it is NaN if either variable is constant, as R's cor has it NA
+func correlation(xs, ys []float64) (r, p float64, n int) {
+	var sx, sy float64
+	var firstX, firstY float64
+	constantX, constantY := true, true
+	for index := range xs {
+		x, y := xs[index], ys[index]
+		if math.IsNaN(x) || math.IsNaN(y) {
+			continue
+		}
+		if n == 0 {
+			firstX, firstY = x, y
+		}
+		constantX = constantX && x == firstX
+		constantY = constantY && y == firstY
+		sx += x
+		sy += y
+		n++
+	}
+	if n < 3 || constantX || constantY {
+		return math.NaN(), math.NaN(), n
+	}
+	// sum the products of the deviations from the means, which keeps the precision that sums of
+	// squares lose on large values with little spread
+	fn := float64(n)
+	mx, my := sx/fn, sy/fn
+	var sxx, syy, sxy float64
+	for index := range xs {
+		x, y := xs[index], ys[index]
+		if math.IsNaN(x) || math.IsNaN(y) {
+			continue
+		}
+		sxx += (x - mx) * (x - mx)
+		syy += (y - my) * (y - my)
+		sxy += (x - mx) * (y - my)
+	}
+	r = sxy / math.Sqrt(sxx*syy)
+	if math.Abs(r) >= 1 {
+		return r, 0, n
+	}
+	t := r * math.Sqrt((fn-2)/(1-r*r))
+	return r, tTest(t, fn-2), n
+}
+
+// Welch's two sample t test, as R's t.test, giving the t statistic, its degrees of freedom and two sided p value
+func welchTest(xs, ys []float64) (t, df, p float64) {
+	mx, nx := mean(xs)
+	my, ny := mean(ys)
+	if nx < 2 || ny < 2 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	vx := sd(xs) * sd(xs) / float64(nx)
+	vy := sd(ys) * sd(ys) / float64(ny)
+	t = (mx - my) / math.Sqrt(vx+vy)
+	df = (vx + vy) * (vx + vy) / (vx*vx/float64(nx-1) + vy*vy/float64(ny-1))
+	return t, df, tTest(t, df)
+}
+
+// the least squares slope of ys on xs with its standard error and two sided p value, as lm reports them
+func slopeTest(xs, ys []float64) (slope, se, p float64) {
+	// leave out the pairs with a NaN, as lm does by default
+	px := make([]float64, 0, len(xs))
+	py := make([]float64, 0, len(ys))
+	for index := range xs {
+		if !math.IsNaN(xs[index]) && !math.IsNaN(ys[index]) {
+			px = append(px, xs[index])
+			py = append(py, ys[index])
+		}
+	}
+	mx, n := mean(px)
+	my, _ := mean(py)
+	if n < 3 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	var sxx, sxy float64
+	for index := range px {
+		sxx += (px[index] - mx) * (px[index] - mx)
+		sxy += (px[index] - mx) * (py[index] - my)
+	}
+	slope = sxy / sxx
+	residuals := 0.0
+	for index := range px {
+		residual := py[index] - my - slope*(px[index]-mx)
+		residuals += residual * residual
+	}
+	se = math.Sqrt(residuals / float64(n-2) / sxx)
+	return slope, se, tTest(slope/se, float64(n-2))
+}
+
+// format a number for a result table, with NA for missing values as R writes them
+func formatStat(value float64) string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return "NA"
+	}
+	return strconv.FormatFloat(value, 'f', 6, 64)
+}
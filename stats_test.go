@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCorrelation(t *testing.T) {
+	xs := []float64{1, 2, 3, 4, 5}
+	ys := []float64{2, 4, 5, 4, 5}
+	// the deviations give sxy = 6, sxx = 10 and syy = 6, so r = 6 / sqrt(60) with t = 2.1213 on 3 df
+	r, p, n := correlation(xs, ys)
+	checkClose(t, "r", r, 6/math.Sqrt(60), 1e-12)
+	checkClose(t, "p", p, 0.12402706265755457, 1e-9)
+	if n != 5 {
+		t.Errorf("n is %d, expected 5", n)
+	}
+
+	// times in milliseconds since an epoch are large with little spread, where sums of squares lose every digit
+	shifted := make([]float64, len(xs))
+	for index, x := range xs {
+		shifted[index] = 1.6e12 + x
+	}
+	r, _, _ = correlation(shifted, ys)
+	checkClose(t, "shifted r", r, 6/math.Sqrt(60), 1e-9)
+
+	// pairs with a NaN are left out
+	r, _, n = correlation(append([]float64{math.NaN(), 7}, xs...), append([]float64{1, math.NaN()}, ys...))
+	checkClose(t, "r without NaN", r, 6/math.Sqrt(60), 1e-12)
+	if n != 5 {
+		t.Errorf("n is %d without the NaN pairs, expected 5", n)
+	}
+	for _, test := range []struct {
+		name   string
+		xs, ys []float64
+	}{
+		{"constant", []float64{3, 3, 3, 3}, ys[:4]},
+		{"too few pairs", xs[:2], ys[:2]},
+	} {
+		if r, p, _ := correlation(test.xs, test.ys); !math.IsNaN(r) || !math.IsNaN(p) {
+			t.Errorf("%s: r %g and p %g, expected NaN", test.name, r, p)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SubjectInfo is what is known about a subject apart from their data
+type SubjectInfo struct {
+	Subject    int    `json:"subject"`
+	Gender     string `json:"gender"`
+	Age        string `json:"age"`
+	Handedness string `json:"handedness"`
+	// the date of the session, as 2006-01-02
+	Session  string `json:"session"`
+	Excluded bool   `json:"excluded"`
+	// why the subject is excluded
+	Reason string `json:"reason"`
+}
+
+// the columns of a subjects file, in order
+var subjectColumns = []string{"subject", "gender", "age", "handedness", "session", "excluded", "reason"}
+
+// the columns joined onto result tables with a subject column
+var subjectJoinColumns = []string{"gender", "age", "handedness", "session", "excluded"}
+
+// the registry of subjects in a dataset by number
+type subjectRegistry map[int]*SubjectInfo
+
+// the file names a registry is looked for under a data root when none is given
+var registryNames = []string{"subjects.csv", "subjects.json"}
+
+// get a column of a subject's entry
+func (s *SubjectInfo) column(name string) string {
+	switch name {
+	case "subject":
+		return strconv.Itoa(s.Subject)
+	case "gender":
+		return s.Gender
+	case "age":
+		return s.Age
+	case "handedness":
+		return s.Handedness
+	case "session":
+		return s.Session
+	case "excluded":
+		return strconv.FormatBool(s.Excluded)
+	case "reason":
+		return s.Reason
+	}
+	return ""
+}
+
+// check the values of an entry. the joined columns are written into comma separated tables as they are, so the
+// gender, the only one that is free text, must not hold a separator
+func (s *SubjectInfo) check() error {
+	if strings.ContainsAny(s.Gender, ",\"\r\n") {
+		return fmt.Errorf("subject %d has a gender %q with a comma, quote or line break", s.Subject, s.Gender)
+	}
+	if s.Age != "" {
+		if age, err := strconv.Atoi(s.Age); err != nil || age <= 0 {
+			return fmt.Errorf("subject %d has a bad age %q", s.Subject, s.Age)
+		}
+	}
+	switch s.Handedness {
+	case "", "left", "right", "ambidextrous":
+	default:
+		return fmt.Errorf("subject %d has handedness %q, expected left, right or ambidextrous", s.Subject, s.Handedness)
+	}
+	if s.Session != "" {
+		if _, err := time.Parse("2006-01-02", s.Session); err != nil {
+			return fmt.Errorf("subject %d has a session date %q that is not like 2006-01-02", s.Subject, s.Session)
+		}
+	}
+	return nil
+}
+
+// read a registry from a CSV file with a header of subjectColumns, or a JSON array of entries if the file ends in .json
+func readSubjectRegistry(path string) (subjectRegistry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*SubjectInfo, 0)
+	if strings.HasSuffix(path, ".json") {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	} else {
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.TrimLeadingSpace = true
+		reader.Comment = '#'
+		reader.FieldsPerRecord = -1
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("%s has no header", path)
+		}
+		columns := make(map[string]int)
+		for index, name := range records[0] {
+			columns[strings.TrimSpace(name)] = index
+		}
+		if _, ok := columns["subject"]; !ok {
+			return nil, fmt.Errorf("%s has no subject column", path)
+		}
+		for line, record := range records[1:] {
+			get := func(name string) string {
+				if index, ok := columns[name]; ok && index < len(record) {
+					return strings.TrimSpace(record[index])
+				}
+				return ""
+			}
+			entry := &SubjectInfo{Gender: get("gender"), Age: get("age"), Handedness: get("handedness"),
+				Session: get("session"), Reason: get("reason")}
+			if entry.Subject, err = strconv.Atoi(get("subject")); err != nil {
+				return nil, fmt.Errorf("%s:%d: bad subject %q", path, line+2, get("subject"))
+			}
+			if excluded := get("excluded"); excluded != "" {
+				if entry.Excluded, err = strconv.ParseBool(excluded); err != nil {
+					return nil, fmt.Errorf("%s:%d: bad excluded value %q", path, line+2, excluded)
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	registry := make(subjectRegistry)
+	for _, entry := range entries {
+		if _, ok := registry[entry.Subject]; ok {
+			return nil, fmt.Errorf("%s has subject %d more than once", path, entry.Subject)
+		}
+		if err := entry.check(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		registry[entry.Subject] = entry
+	}
+	return registry, nil
+}
+
+// write a registry as CSV in subject order
+func (r subjectRegistry) write(w io.Writer) error {
+	subjects := make([]int, 0, len(r))
+	for subject := range r {
+		subjects = append(subjects, subject)
+	}
+	sort.Ints(subjects)
+	writer := csv.NewWriter(w)
+	writer.Write(subjectColumns)
+	for _, subject := range subjects {
+		record := make([]string, len(subjectColumns))
+		for index, name := range subjectColumns {
+			record[index] = r[subject].column(name)
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// find the registry of a data root: the given path, or the first of registryNames under the root if the path is empty.
+// it returns an empty path without a registry if there is none
+func findSubjectRegistry(root, path string) (string, subjectRegistry, error) {
+	if path == "" {
+		for _, name := range registryNames {
+			if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+				path = filepath.Join(root, name)
+				break
+			}
+		}
+		if path == "" {
+			return "", nil, nil
+		}
+	}
+	registry, err := readSubjectRegistry(path)
+	return path, registry, err
+}
+
+// the subject directories of a dataset without an entry in its registry
+func (l *Layout) unregisteredSubjects() []int {
+	missing := make([]int, 0)
+	for _, subject := range l.Subjects() {
+		if _, ok := l.registry[subject]; !ok {
+			missing = append(missing, subject)
+		}
+	}
+	return missing
+}
+
+// leave the subjects the registry excludes out of an analysis
+func (l *Layout) includedSubjects(subjects []int) []int {
+	included := make([]int, 0, len(subjects))
+	excluded := make([]int, 0)
+	for _, subject := range subjects {
+		if info, ok := l.registry[subject]; ok && info.Excluded {
+			excluded = append(excluded, subject)
+			continue
+		}
+		included = append(included, subject)
+	}
+	if len(excluded) > 0 {
+		fmt.Fprintf(os.Stderr, "leaving out subjects excluded in %s: %v\n", l.registryPath, excluded)
+	}
+	return included
+}
+
+// subjectJoinWriter adds the registry's columns to each table written through it that has a subject column.
+// tables are separated by blank lines and start with a header, and other tables pass through unchanged
+type subjectJoinWriter struct {
+	w        io.WriteCloser
+	registry subjectRegistry
+	// the index of the subject column of the current table, -1 before a header and -2 in a table without one
+	column  int
+	pending bytes.Buffer
+}
+
+// join the registry's columns onto the tables written to a result file, if the dataset has a registry
+func (l *Layout) joinSubjects(w io.WriteCloser) io.WriteCloser {
+	if l.registry == nil {
+		return w
+	}
+	return &subjectJoinWriter{w: w, registry: l.registry, column: -1}
+}
+
+func (j *subjectJoinWriter) Write(p []byte) (int, error) {
+	j.pending.Write(p)
+	for {
+		data := j.pending.Bytes()
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			return len(p), nil
+		}
+		line := string(data[:end])
+		j.pending.Next(end + 1)
+		if _, err := io.WriteString(j.w, j.joinLine(line)+"\n"); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// add the columns to a line of a table
+func (j *subjectJoinWriter) joinLine(line string) string {
+	if strings.TrimSpace(line) == "" {
+		j.column = -1
+		return line
+	}
+	fields := strings.Split(line, ",")
+	if j.column == -1 {
+		j.column = -2
+		for index, field := range fields {
+			if strings.TrimSpace(field) == "subject" {
+				j.column = index
+				return line + ", " + strings.Join(subjectJoinColumns, ", ")
+			}
+		}
+		return line
+	}
+	if j.column < 0 || j.column >= len(fields) {
+		return line
+	}
+	values := make([]string, len(subjectJoinColumns))
+	subject, err := strconv.Atoi(strings.TrimSpace(fields[j.column]))
+	info, ok := j.registry[subject]
+	for index, name := range subjectJoinColumns {
+		values[index] = "NA"
+		if err == nil && ok {
+			if value := info.column(name); value != "" {
+				values[index] = value
+			}
+		}
+	}
+	return line + ", " + strings.Join(values, ", ")
+}
+
+func (j *subjectJoinWriter) Close() error {
+	if j.pending.Len() > 0 {
+		if _, err := io.WriteString(j.w, j.joinLine(j.pending.String())); err != nil {
+			return err
+		}
+	}
+	return j.w.Close()
+}
+
+func init() {
+	addCommand(&command{
+		name:  "subjects",
+		short: "check the registry of subjects against the subject directories",
+		long: `Subjects checks the registry of subjects of a dataset and lists it. The registry holds each
+subject's gender, age, handedness, session date (as 2006-01-02) and whether they are excluded from
+analyses, with the reason. It is subjects.csv or subjects.json in the root unless -subjects names
+another file. The CSV file has a header naming the columns subject, gender, age, handedness,
+session, excluded and reason; the JSON file is an array of objects with those keys.
+
+Every command that reads a dataset loads the registry. Result tables with a subject column get
+the gender, age, handedness, session and excluded columns of each subject, NA where they are not
+known. Every table of subjects has one, including r1.txt, all.txt and workload.txt, so analysis.R
+reads the gender from r1.txt rather than from the registry. The gender may not contain a comma,
+quote or line break, as it is written into the tables as it is. Excluded subjects are left out
+of the analyses of summary, anova, compare and the like but are still aggregated. The gender,
+age, handedness and session are also factors that summary and hist can group by.
+
+Subjects fails if a subject directory has no entry. With -init, entries with empty fields are
+added for those subjects, writing the registry as subjects.csv in the root if there is none.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			var initialise bool
+			flags.BoolVar(&initialise, "init", false, "Add entries for subject directories that have none")
+			return func(args []string) error {
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				missing := layout.unregisteredSubjects()
+				if initialise && len(missing) > 0 {
+					path := layout.registryPath
+					if path == "" {
+						path = filepath.Join(layout.Root, registryNames[0])
+						layout.registry = make(subjectRegistry)
+					}
+					if strings.HasSuffix(path, ".json") {
+						return fmt.Errorf("-init only adds entries to CSV registries, not %s", path)
+					}
+					for _, subject := range missing {
+						layout.registry[subject] = &SubjectInfo{Subject: subject}
+					}
+					var data bytes.Buffer
+					if err := layout.registry.write(&data); err != nil {
+						return err
+					}
+					if err := writeFileAtomic(path, data.Bytes()); err != nil {
+						return err
+					}
+					fmt.Fprintf(os.Stderr, "added %d subjects to %s\n", len(missing), path)
+					missing = nil
+				}
+				if layout.registry == nil {
+					return fmt.Errorf("no subjects file in %s", layout.Root)
+				}
+				if err := layout.registry.write(os.Stdout); err != nil {
+					return err
+				}
+				if len(missing) > 0 {
+					return fmt.Errorf("subject directories without an entry: %v", missing)
+				}
+				return nil
+			}
+		},
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadSubjectRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "subjects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, test := range []struct {
+		name, contents, err string
+	}{
+		{"subjects.csv", "subject,gender,age\n1,female,24\n2,,\n", ""},
+		{"subjects.csv", "subject,gender\n1,\"female, cis\"\n", `subject 1 has a gender "female, cis" with a comma`},
+		{"subjects.csv", "subject,gender\n1,\"fe\"\"male\"\n", "with a comma, quote or line break"},
+		{"subjects.json", `[{"subject": 1, "gender": "fe\nmale"}]`, "with a comma, quote or line break"},
+		{"subjects.csv", "subject,handedness\n1,both\n", `handedness "both"`},
+		{"subjects.csv", "subject,age\n1,24\n1,25\n", "subject 1 more than once"},
+	} {
+		path := filepath.Join(dir, test.name)
+		writeTestFile(t, path, test.contents)
+		registry, err := readSubjectRegistry(path)
+		if test.err == "" {
+			if err != nil || len(registry) != 2 || registry[1].Gender != "female" || registry[1].Age != "24" {
+				t.Errorf("%q: read %v and %v", test.contents, registry, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got error %v, expected %s", test.contents, err, test.err)
+		}
+	}
+}
+
+// the registry in the repository holds the genders analysis.R once had written into it
+func TestCommittedRegistry(t *testing.T) {
+	registry, err := readSubjectRegistry(filepath.Join("output", "subjects.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	genders := []string{"female", "male", "female", "male", "female", "male", "male", "female", "male", "female",
+		"male", "female", "male", "male", "male", "male", "female", "female", "female", "female"}
+	for index, gender := range genders {
+		if info, ok := registry[index+1]; !ok || info.Gender != gender {
+			t.Errorf("subject %d is not %s", index+1, gender)
+		}
+	}
+}
+
+func TestSubjectJoinWriter(t *testing.T) {
+	registry := subjectRegistry{1: {Subject: 1, Gender: "female", Age: "24"}, 2: {Subject: 2, Excluded: true}}
+	var buffer bytes.Buffer
+	w := (&Layout{registry: registry}).joinSubjects(nopWriteCloser{&buffer})
+	// a table with a subject column, one without and one with it in another place, the last without a final newline
+	for _, part := range []string{"block, subject, x\nb, 1, 2\nb, 2", ", 3\nb, 3, 4\n\n", "x, y\n1, 2\n\nsubject\n1"} {
+		if _, err := w.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := `block, subject, x, gender, age, handedness, session, excluded
+b, 1, 2, female, 24, NA, NA, false
+b, 2, 3, NA, NA, NA, NA, true
+b, 3, 4, NA, NA, NA, NA, NA
+
+x, y
+1, 2
+
+subject, gender, age, handedness, session, excluded
+1, female, 24, NA, NA, false`
+	if buffer.String() != want {
+		t.Errorf("wrote\n%s\nexpected\n%s", buffer.String(), want)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the variables of an observation that identify what it was measured under, which observations can be grouped by
+var observationFactors = []string{"subject", "source", "block", "trial", "iteration", "practice", "type",
+	"targets", "speed", "oprange", "difficulty", "gender", "age", "handedness", "session"}
+
+// test if a name is a factor of observations
+func isFactor(name string) bool {
+	for _, factor := range observationFactors {
+		if factor == name {
+			return true
+		}
+	}
+	return false
+}
+
+// format a factor of an observation for a result table
+func (o Observation) level(name string) string {
+	switch value := o.field(name).(type) {
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case string:
+		return value
+	}
+	return ""
+}
+
+// compare two levels of a factor, numerically if both are numbers
+func levelLess(a, b string) bool {
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	if errX == nil && errY == nil {
+		return x < y
+	}
+	return a < b
+}
+
+// a group of observations with the same levels of some factors
+type observationGroup struct {
+	Levels []string
+	// the indices of the observations in the group
+	Members []int
+}
+
+// group observations by the levels of factors, in order of the levels
+func groupObservations(observations []Observation, factors []string) []*observationGroup {
+	byKey := make(map[string]*observationGroup)
+	groups := make([]*observationGroup, 0)
+	for index, o := range observations {
+		levels := make([]string, len(factors))
+		for f, factor := range factors {
+			levels[f] = o.level(factor)
+		}
+		key := strings.Join(levels, "\x00")
+		group, ok := byKey[key]
+		if !ok {
+			group = &observationGroup{Levels: levels}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.Members = append(group.Members, index)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		for f := range factors {
+			if groups[i].Levels[f] != groups[j].Levels[f] {
+				return levelLess(groups[i].Levels[f], groups[j].Levels[f])
+			}
+		}
+		return false
+	})
+	return groups
+}
+
+// normalise a measure within subjects as normDataWithin does: each value has the mean of its subject, within
+// the groups of the between-subject factors, removed and the grand mean of all values added back
+func normWithin(observations []Observation, measure string, between []string) []float64 {
+	values := make([]float64, len(observations))
+	for index, o := range observations {
+		values[index] = o.measure(measure)
+	}
+	grand, _ := mean(values)
+	normed := make([]float64, len(observations))
+	for _, group := range groupObservations(observations, append([]string{"subject"}, between...)) {
+		v := make([]float64, len(group.Members))
+		for i, index := range group.Members {
+			v[i] = values[index]
+		}
+		subjectMean, _ := mean(v)
+		for _, index := range group.Members {
+			normed[index] = values[index] - subjectMean + grand
+		}
+	}
+	return normed
+}
+
+// the summary of a measure over a group of observations, as summarySE and summarySEwithin report it
+type groupSummary struct {
+	Levels []string
+	N      int
+	Mean   float64
+	SD     float64
+	SE     float64
+	CI     float64
+	// the same from the values normed within subjects with Morey's correction, or NaN if not computed
+	SDWithin, SEWithin, CIWithin float64
+}
+
+// settings of a summary
+type summaryOptions struct {
+	// the factors to group by
+	by []string
+	// the factors in by that vary between subjects rather than within them
+	between    []string
+	confidence float64
+	// compute the Cousineau-Morey within-subject intervals
+	within bool
+}
+
+// summarise a measure over each group of observations. the within-subject intervals use the values normed
+// within subjects, with the standard deviation scaled by sqrt(M/(M-1)) where M is the number of conditions
+// of the within-subject factors, as summarySEwithin does
+func summarise(observations []Observation, measure string, options summaryOptions) []groupSummary {
+	var normed []float64
+	correction := math.NaN()
+	if options.within {
+		normed = normWithin(observations, measure, options.between)
+		conditions := 1
+		for _, factor := range options.by {
+			if contains(options.between, factor) {
+				continue
+			}
+			levels := make(map[string]bool)
+			for _, o := range observations {
+				levels[o.level(factor)] = true
+			}
+			conditions *= len(levels)
+		}
+		if conditions > 1 {
+			correction = math.Sqrt(float64(conditions) / float64(conditions-1))
+		}
+	}
+
+	summaries := make([]groupSummary, 0)
+	for _, group := range groupObservations(observations, options.by) {
+		values := make([]float64, len(group.Members))
+		for i, index := range group.Members {
+			values[i] = observations[index].measure(measure)
+		}
+		m, n := mean(values)
+		if n == 0 {
+			continue
+		}
+		summary := groupSummary{Levels: group.Levels, N: n, Mean: m, SD: sd(values),
+			SDWithin: math.NaN(), SEWithin: math.NaN(), CIWithin: math.NaN()}
+		multiplier := tQuantile(options.confidence/2+0.5, float64(n-1))
+		summary.SE = summary.SD / math.Sqrt(float64(n))
+		summary.CI = summary.SE * multiplier
+		if normed != nil && !math.IsNaN(correction) {
+			v := make([]float64, len(group.Members))
+			for i, index := range group.Members {
+				v[i] = normed[index]
+			}
+			summary.SDWithin = sd(v) * correction
+			summary.SEWithin = summary.SDWithin / math.Sqrt(float64(n))
+			summary.CIWithin = summary.SEWithin * multiplier
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+func contains(list []string, name string) bool {
+	for _, item := range list {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// split a comma separated list of names, checking each one
+func parseNameList(list string, valid func(string) bool, what string) ([]string, error) {
+	names := make([]string, 0)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !valid(name) {
+			return nil, fmt.Errorf("unknown %s %q", what, name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// write the summaries of measures as a table with a column for each factor
+func writeSummaries(w io.Writer, observations []Observation, measures []string, options summaryOptions) {
+	header := append(append([]string{}, options.by...), "measure", "N", "mean", "sd", "se", "ci")
+	if options.within {
+		header = append(header, "sdWithin", "seWithin", "ciWithin")
+	}
+	fmt.Fprintln(w, strings.Join(header, ", "))
+	for _, measure := range measures {
+		for _, s := range summarise(observations, measure, options) {
+			row := append(append([]string{}, s.Levels...), measure, strconv.Itoa(s.N),
+				formatStat(s.Mean), formatStat(s.SD), formatStat(s.SE), formatStat(s.CI))
+			if options.within {
+				row = append(row, formatStat(s.SDWithin), formatStat(s.SEWithin), formatStat(s.CIWithin))
+			}
+			fmt.Fprintln(w, strings.Join(row, ", "))
+		}
+	}
+}
+
+func init() {
+	addCommand(&command{
+		name:  "summary",
+		short: "summarise measures by condition with confidence intervals",
+		long: `Summary parses the selected trials and, for each group of iterations with the same levels of
+the -by factors, writes the number of values, mean, standard deviation, standard error and the
+half width of the confidence interval of each -measures, as analysis.R's summarySE did. Values
+that do not apply, such as the target time of addition blocks, are left out.
+
+With -within the table also has the Cousineau-Morey within-subject versions, from values with
+each subject's mean replaced by the grand mean of all values and corrected for the number of
+within-subject conditions, as summarySEwithin computes them. Factors that vary between subjects
+are named with -between and must also be in -by.
+
+The factors are subject, source (human or model), block, trial, iteration, practice, type
+(addition, targeting or main), targets, speed, oprange and difficulty, and the gender, age,
+handedness and session of each subject from the registry of subjects, so -by type,gender
+-between gender compares genders as analysis.R's compareGender did. The measures are those of
+r1.txt and misses, accuracy and concurrency. -where selects iterations as for aggregate, e.g.
+-where '!practice'. -outliers flags outliers by a rule as for aggregate and reports how many
+there are; with -exclude-outliers they are left out of the summaries.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			selection := addAnalysisSelectionFlags(flags)
+			caching := addCacheFlags(flags)
+			outliers := addOutlierFlags(flags)
+			var exclude bool
+			var byList, betweenList, measureList, where, source, out string
+			options := summaryOptions{}
+			flags.StringVar(&byList, "by", "type,speed,difficulty,oprange", "The factors to group by, separated by commas")
+			flags.StringVar(&betweenList, "between", "", "The factors of -by that vary between subjects")
+			flags.StringVar(&measureList, "measures", "complete", "The measures to summarise, separated by commas")
+			flags.Float64Var(&options.confidence, "ci", 0.95, "The confidence level of the intervals")
+			flags.BoolVar(&options.within, "within", false, "Add Cousineau-Morey within-subject intervals")
+			flags.StringVar(&where, "where", "", "Only summarise the iterations for which an expression is true")
+			flags.StringVar(&source, "source", "", "Only use trials from this source, human or model")
+			flags.StringVar(&out, "out", "-", "The file to write, or - for stdout")
+			flags.BoolVar(&exclude, "exclude-outliers", false, "Leave out the iterations flagged by -outliers")
+			return func(args []string) error {
+				rule, err := outliers.rule()
+				if err != nil {
+					return err
+				}
+				if exclude && rule == nil {
+					return fmt.Errorf("-exclude-outliers needs an -outliers rule")
+				}
+				if options.by, err = parseNameList(byList, isFactor, "factor"); err != nil {
+					return err
+				}
+				if options.between, err = parseNameList(betweenList, isFactor, "factor"); err != nil {
+					return err
+				}
+				for _, factor := range options.between {
+					if !contains(options.by, factor) {
+						return fmt.Errorf("between-subject factor %q is not in -by", factor)
+					}
+				}
+				if options.within && contains(options.by, "subject") {
+					return fmt.Errorf("within-subject intervals cannot be computed when grouping by subject")
+				}
+				measures, err := parseNameList(measureList, isMeasure, "measure")
+				if err != nil {
+					return err
+				}
+				if source != "" && source != "human" && source != "model" {
+					return fmt.Errorf("unknown source %q, expected human or model", source)
+				}
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				if where != "" {
+					if selection.options.where, err = parseFilter(where); err != nil {
+						return err
+					}
+				}
+				if selection.options.cache, err = caching.cache(layout); err != nil {
+					return err
+				}
+				observations, err := loadObservations(layout, selection.subjects(layout), source, selection.options)
+				if err != nil {
+					return err
+				}
+				if rule != nil {
+					flags := flagOutliers(observations, rule)
+					fmt.Fprintf(os.Stderr, "%d of %d iterations are outliers by %s\n", countFlags(flags), len(flags), rule)
+					if exclude {
+						kept := make([]Observation, 0, len(observations))
+						for index, o := range observations {
+							if !flags[index] {
+								kept = append(kept, o)
+							}
+						}
+						observations = kept
+					}
+				}
+
+				w, err := layout.openOutput(out)
+				if err != nil {
+					return err
+				}
+				writeSummaries(w, observations, measures, options)
+				return w.Close()
+			}
+		},
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// check that a statistic is within a relative tolerance of what it should be
+func checkClose(t *testing.T, name string, got, want, tolerance float64) {
+	t.Helper()
+	if math.IsNaN(want) {
+		if !math.IsNaN(got) {
+			t.Errorf("%s = %g, expected NaN", name, got)
+		}
+		return
+	}
+	if math.Abs(got-want) > tolerance*math.Max(1, math.Abs(want)) {
+		t.Errorf("%s = %.10g, expected %.10g", name, got, want)
+	}
+}
+
+// two speeds within subjects 1 and 2 with one target and subjects 3 and 4 with two
+func withinFixture() []Observation {
+	observations := make([]Observation, 0)
+	for _, row := range [][4]float64{
+		{1, 1, 1, 3}, {2, 1, 2, 6}, {3, 2, 4, 5}, {4, 2, 7, 9},
+	} {
+		for speed, complete := range map[int]float64{100: row[2], 200: row[3]} {
+			observations = append(observations, Observation{Iteration: Iteration{Subject: int(row[0]),
+				Levels: &IVLevels{TargetNumber: int(row[1]), TargetSpeed: speed}, Complete: complete}})
+		}
+	}
+	return observations
+}
+
+func TestNormWithin(t *testing.T) {
+	observations := withinFixture()
+	normed := normWithin(observations, "complete", []string{"targets"})
+	// the subject means are 2, 4, 4.5 and 8 and the grand mean of all values is 37 / 8, as normDataWithin uses
+	// whatever the between-subject groups are
+	subjectMeans := map[int]float64{1: 2, 2: 4, 3: 4.5, 4: 8}
+	for index, o := range observations {
+		checkClose(t, "normed", normed[index], o.Complete-subjectMeans[o.Subject]+37.0/8, 1e-12)
+	}
+}
+
+func TestSummariseWithin(t *testing.T) {
+	options := summaryOptions{by: []string{"speed", "targets"}, between: []string{"targets"}, confidence: 0.95, within: true}
+	// the normed values of each cell differ by 1 for one target and by 0.5 for two, which summarySEwithin scales by
+	// Morey's correction of sqrt(2 / (2 - 1)) for the two speeds. with 2 subjects in a cell the t quantile is
+	// qt(0.975, 1) = tan(0.475 pi)
+	want := map[string]struct{ mean, sd, sdWithin float64 }{
+		"100 1": {1.5, math.Sqrt(0.5), 1}, "200 1": {4.5, 3 * math.Sqrt(0.5), 1},
+		"100 2": {5.5, 3 * math.Sqrt(0.5), 0.5}, "200 2": {7, 4 * math.Sqrt(0.5), 0.5},
+	}
+	summaries := summarise(withinFixture(), "complete", options)
+	if len(summaries) != len(want) {
+		t.Fatalf("got %d groups, expected %d", len(summaries), len(want))
+	}
+	quantile := math.Tan(0.475 * math.Pi)
+	for _, s := range summaries {
+		w, ok := want[strings.Join(s.Levels, " ")]
+		if !ok || s.N != 2 {
+			t.Errorf("unexpected group %v of %d", s.Levels, s.N)
+			continue
+		}
+		checkClose(t, "mean", s.Mean, w.mean, 1e-12)
+		checkClose(t, "sd", s.SD, w.sd, 1e-12)
+		checkClose(t, "ci", s.CI, w.sd/math.Sqrt2*quantile, 1e-9)
+		checkClose(t, "sdWithin", s.SDWithin, w.sdWithin, 1e-12)
+		checkClose(t, "seWithin", s.SEWithin, w.sdWithin/math.Sqrt2, 1e-12)
+		checkClose(t, "ciWithin", s.CIWithin, w.sdWithin/math.Sqrt2*quantile, 1e-9)
+	}
+
+	// without a within-subject factor there is no correction and so no within-subject interval
+	options.by = []string{"targets"}
+	for _, s := range summarise(withinFixture(), "complete", options) {
+		if !math.IsNaN(s.SDWithin) {
+			t.Errorf("group %v has a within-subject sd %g without within-subject factors", s.Levels, s.SDWithin)
+		}
+	}
+}
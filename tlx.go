@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// the NASA-TLX subscales in the order of the survey, as the survey names them
+var tlxScales = []string{"mental", "physical", "temporal", "performance", "effort", "frustration"}
+
+// the titles tlxweights.dart gives the subscales in weights.txt, in the order of tlxScales
+var tlxWeightTitles = []string{"Mental Demand", "Physical Demand", "Temporal Demand", "Performance", "Effort", "Frustration"}
+
+// read a file ExpDataServer wrote as a prefix such as "survey: " and JSON, possibly over several lines
+func readPrefixedJSON(path, prefix string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	text := strings.Replace(string(data), "\n", "", -1)
+	if !strings.HasPrefix(text, prefix) {
+		return fmt.Errorf("%s does not start with %q", path, prefix)
+	}
+	if err := json.Unmarshal([]byte(text[len(prefix):]), v); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// read a subject's weights.txt: how many of the 15 pairwise comparisons each subscale won, in the order of tlxScales
+func readTLXWeights(layout *Layout, subject int) ([]float64, error) {
+	path := filepath.Join(layout.SubjectDir(subject), "weights.txt")
+	counts := make(map[string]float64)
+	if err := readPrefixedJSON(path, "weights: ", &counts); err != nil {
+		return nil, err
+	}
+	weights := make([]float64, len(tlxWeightTitles))
+	for index, title := range tlxWeightTitles {
+		count, ok := counts[title]
+		if !ok {
+			return nil, fmt.Errorf("%s has no weight for %s", path, title)
+		}
+		weights[index] = count
+	}
+	return weights, nil
+}
+
+// check that weights are counts of the 15 pairwise comparisons each subscale won: whole, not negative and summing to 15
+func checkTLXWeights(weights []float64) error {
+	sum := 0.0
+	for index, weight := range weights {
+		if weight < 0 || weight != math.Trunc(weight) {
+			return fmt.Errorf("the weight of %s is %g, not a count", tlxWeightTitles[index], weight)
+		}
+		sum += weight
+	}
+	if sum != 15 {
+		return fmt.Errorf("the weights sum to %g, not 15", sum)
+	}
+	return nil
+}
+
+// the weights that make the weighted score the mean rating, for subjects whose weights cannot be used
+var unweightedTLX = []float64{2.5, 2.5, 2.5, 2.5, 2.5, 2.5}
+
+// choose the weights of a subject's surveys: the subject's weights if they are valid, otherwise as fallback says,
+// equal weights for unweighted, none for na, or an error for fail. it returns the weighting BlockTLX records
+func chooseTLXWeights(layout *Layout, subject int, fallback string) ([]float64, string, error) {
+	weights, err := readTLXWeights(layout, subject)
+	if err == nil {
+		err = checkTLXWeights(weights)
+	}
+	if err == nil {
+		return weights, "weights", nil
+	}
+	switch fallback {
+	case "fail":
+		return nil, "", err
+	case "unweighted":
+		fmt.Fprintf(os.Stderr, "subject %d has unusable weights, using equal weights: %v\n", subject, err)
+		return unweightedTLX, "unweighted", nil
+	}
+	fmt.Fprintf(os.Stderr, "subject %d has unusable weights, the weighted scores are NA: %v\n", subject, err)
+	return nil, "none", nil
+}
+
+// check an -invalid-weights flag
+func checkTLXFallback(fallback string) error {
+	if fallback != "na" && fallback != "unweighted" && fallback != "fail" {
+		return fmt.Errorf("unknown -invalid-weights %q, expected na, unweighted or fail", fallback)
+	}
+	return nil
+}
+
+// read a block's survey.txt: the rating of each subscale in the order of tlxScales
+func readTLXSurvey(layout *Layout, subject int, block string) ([]float64, error) {
+	path := filepath.Join(layout.BlockDir(subject, block), "survey.txt")
+	responses := make(map[string]float64)
+	if err := readPrefixedJSON(path, "survey: ", &responses); err != nil {
+		return nil, err
+	}
+	ratings := make([]float64, len(tlxScales))
+	for index, scale := range tlxScales {
+		rating, ok := responses[scale]
+		if !ok {
+			return nil, fmt.Errorf("%s has no rating for %s", path, scale)
+		}
+		ratings[index] = rating
+	}
+	return ratings, nil
+}
+
+// BlockTLX is a subject's NASA-TLX survey after a block
+type BlockTLX struct {
+	Subject int
+	Block   string
+	Levels  *IVLevels
+	// the rating of each subscale in the order of tlxScales
+	Ratings []float64
+	// the sum of the ratings, and their sum weighted by the subject's weights over 15 as getTLX computed it,
+	// NaN without weights
+	Raw, Weighted float64
+	// how the weighted score was computed: weights for the subject's weights, unweighted for equal weights in
+	// place of missing or invalid ones, or none
+	Weighting string
+}
+
+// score the surveys of a subject's blocks, in block order. blocks that are not practice and have no survey are returned
+// as missing
+func subjectTLX(layout *Layout, subject int, weights []float64, weighting string) ([]BlockTLX, []string, error) {
+	blocks := layout.Blocks(subject)
+	sort.Slice(blocks, func(i, j int) bool { return nameNumber(blocks[i]) < nameNumber(blocks[j]) })
+	scores := make([]BlockTLX, 0)
+	missing := make([]string, 0)
+	for _, block := range blocks {
+		levels := getBlockIVLevels(layout, subject, block)
+		if levels == nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(layout.BlockDir(subject, block), "survey.txt")); os.IsNotExist(err) {
+			if !levels.Practice {
+				missing = append(missing, block)
+			}
+			continue
+		}
+		ratings, err := readTLXSurvey(layout, subject, block)
+		if err != nil {
+			return nil, nil, err
+		}
+		score := BlockTLX{Subject: subject, Block: block, Levels: levels, Ratings: ratings, Weighted: math.NaN(), Weighting: weighting}
+		for _, rating := range ratings {
+			score.Raw += rating
+		}
+		if weights != nil {
+			score.Weighted = 0
+			for index, rating := range ratings {
+				score.Weighted += rating * weights[index] / 15
+			}
+		}
+		scores = append(scores, score)
+	}
+	return scores, missing, nil
+}
+
+// print the header of the TLX table
+func printTLXHeader(w io.Writer) {
+	fmt.Fprintf(w, "subject, block, practice, targets, speed, oprange, difficulty, %s, raw, weighted, weighting\n", strings.Join(tlxScales, ", "))
+}
+
+// print the survey of a block as a row of the TLX table
+func (t BlockTLX) print(w io.Writer) {
+	fmt.Fprintf(w, "%d, %s, %t, %d, %d, %v, %d", t.Subject, t.Block, t.Levels.Practice,
+		t.Levels.TargetNumber, t.Levels.TargetSpeed, t.Levels.AdditionDifficulty, t.Levels.TargetDifficulty)
+	for _, rating := range t.Ratings {
+		fmt.Fprintf(w, ", %g", rating)
+	}
+	fmt.Fprintf(w, ", %g, %s, %s\n", t.Raw, formatStat(t.Weighted), t.Weighting)
+}
+
+func init() {
+	addCommand(&command{
+		name:  "tlx",
+		short: "score the NASA-TLX surveys of each block",
+		long: `Tlx reads the survey.txt that ExpDataServer writes after each block and the weights.txt of
+each subject, and writes a table with a row for each block that has a survey: the block
+conditions, the rating of each subscale, the raw sum of the ratings and the weighted score, the
+ratings weighted by how many of the 15 pairwise comparisons each subscale won, over 15, as
+analysis.R's getTLX computed them.
+
+The weights must be the counts of tlxweights.dart: whole numbers, not negative, summing to 15.
+Subjects whose weights.txt is missing or invalid are reported and -invalid-weights decides what
+happens to their weighted scores: na leaves them NA, unweighted uses equal weights so the
+weighted score is the mean rating, and fail stops with an error. The weighting column records
+which was done for each row: weights, unweighted or none.
+
+Blocks that are not practice blocks and have no survey are reported for each subject.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			selection := addAnalysisSelectionFlags(flags)
+			var out, fallback string
+			flags.StringVar(&out, "out", "-", "The file to write, or - for stdout")
+			flags.StringVar(&fallback, "invalid-weights", "na", "What to do for subjects with missing or invalid weights: na, unweighted or fail")
+			return func(args []string) error {
+				if err := checkTLXFallback(fallback); err != nil {
+					return err
+				}
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				w, err := layout.openOutput(out)
+				if err != nil {
+					return err
+				}
+				printTLXHeader(w)
+				failed := false
+				for _, subject := range selection.subjects(layout) {
+					weights, weighting, err := chooseTLXWeights(layout, subject, fallback)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "not writing TLX of subject %d: %v\n", subject, err)
+						failed = true
+						continue
+					}
+					scores, missing, err := subjectTLX(layout, subject, weights, weighting)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "not writing TLX of subject %d: %v\n", subject, err)
+						failed = true
+						continue
+					}
+					if len(missing) > 0 {
+						fmt.Fprintf(os.Stderr, "subject %d has no survey for blocks %s\n", subject, strings.Join(missing, ", "))
+					}
+					for _, score := range scores {
+						score.print(w)
+					}
+				}
+				if err := w.Close(); err != nil {
+					return err
+				}
+				if failed {
+					return fmt.Errorf("some subjects failed")
+				}
+				return nil
+			}
+		},
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tlxLayout(t *testing.T) *Layout {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "tlx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	layout, err := parseLayout(dir, defaultLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layout
+}
+
+func TestCheckTLXWeights(t *testing.T) {
+	for _, test := range []struct {
+		weights []float64
+		err     string
+	}{
+		{[]float64{5, 0, 4, 2, 3, 1}, ""},
+		{[]float64{0, 0, 0, 0, 0, 15}, ""},
+		{[]float64{5, 0, 4, 2, 3, 0}, "the weights sum to 14, not 15"},
+		{[]float64{5, 1, 4, 2, 3, 1}, "the weights sum to 16, not 15"},
+		{[]float64{6, -1, 4, 2, 3, 1}, "the weight of Physical Demand is -1, not a count"},
+		{[]float64{5, 0.5, 3.5, 2, 3, 1}, "the weight of Physical Demand is 0.5, not a count"},
+	} {
+		err := checkTLXWeights(test.weights)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%v: got error %v, expected %q", test.weights, err, test.err)
+		}
+	}
+}
+
+func TestChooseTLXWeights(t *testing.T) {
+	valid := []float64{5, 0, 4, 2, 3, 1}
+	for _, test := range []struct {
+		name, file string
+		// the error of fail, or empty if the weights are used
+		err string
+	}{
+		{"valid", `weights: {"Mental Demand": 5, "Physical Demand": 0, "Temporal Demand": 4, "Performance": 2, "Effort": 3, "Frustration": 1}`, ""},
+		{"several lines", "weights: {\"Mental Demand\": 5, \"Physical Demand\": 0,\n\"Temporal Demand\": 4, \"Performance\": 2,\n\"Effort\": 3, \"Frustration\": 1}\n", ""},
+		{"sum 14", `weights: {"Mental Demand": 5, "Physical Demand": 0, "Temporal Demand": 4, "Performance": 2, "Effort": 3, "Frustration": 0}`, "sum to 14"},
+		{"negative", `weights: {"Mental Demand": 6, "Physical Demand": -1, "Temporal Demand": 4, "Performance": 2, "Effort": 3, "Frustration": 1}`, "is -1, not a count"},
+		{"fractional", `weights: {"Mental Demand": 5, "Physical Demand": 0.5, "Temporal Demand": 3.5, "Performance": 2, "Effort": 3, "Frustration": 1}`, "is 0.5, not a count"},
+		{"missing title", `weights: {"Mental Demand": 6, "Physical Demand": 0, "Temporal Demand": 4, "Performance": 2, "Effort": 3}`, "has no weight for Frustration"},
+		{"no prefix", `{"Mental Demand": 5}`, `does not start with "weights: "`},
+		{"bad JSON", `weights: {"Mental Demand": 5,`, "unexpected end of JSON input"},
+		{"missing", "", "no such file"},
+	} {
+		layout := tlxLayout(t)
+		if test.name != "missing" {
+			writeTestFile(t, filepath.Join(layout.SubjectDir(1), "weights.txt"), test.file)
+		}
+		for _, fallback := range []string{"na", "unweighted", "fail"} {
+			weights, weighting, err := chooseTLXWeights(layout, 1, fallback)
+			switch {
+			case test.err == "":
+				if err != nil || weighting != "weights" || !reflect.DeepEqual(weights, valid) {
+					t.Errorf("%s, %s: got %v, %q and %v, expected the weights", test.name, fallback, weights, weighting, err)
+				}
+			case fallback == "fail":
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("%s, fail: got error %v, expected %s", test.name, err, test.err)
+				}
+			case fallback == "unweighted":
+				if err != nil || weighting != "unweighted" || !reflect.DeepEqual(weights, unweightedTLX) {
+					t.Errorf("%s, unweighted: got %v, %q and %v, expected equal weights", test.name, weights, weighting, err)
+				}
+			default:
+				if err != nil || weighting != "none" || weights != nil {
+					t.Errorf("%s, na: got %v, %q and %v, expected no weights", test.name, weights, weighting, err)
+				}
+			}
+		}
+	}
+	if err := checkTLXFallback("zero"); err == nil {
+		t.Errorf("-invalid-weights zero was accepted")
+	}
+}
+
+func TestSubjectTLX(t *testing.T) {
+	layout := tlxLayout(t)
+	block := func(name, levels, survey string) {
+		writeTestFile(t, filepath.Join(layout.BlockDir(1, name), "block.txt"), levels)
+		if survey != "" {
+			writeTestFile(t, filepath.Join(layout.BlockDir(1, name), "survey.txt"), survey)
+		}
+	}
+	practice := `{"trials": 1, "practice": true, "targetNumber": 0, "targetSpeed": 0, "additionDifficulty": [13, 25], "targetDifficulty": 0}`
+	dual := `{"trials": 1, "practice": false, "targetNumber": 1, "targetSpeed": 200, "additionDifficulty": [1, 12], "targetDifficulty": 1}`
+	// a practice block without a survey is not missing, a dual task block without one is
+	block("block0", practice, "")
+	block("block1", dual, "survey: {\"mental\": 70, \"physical\": 36, \"temporal\": 67,\n\"performance\": 52, \"effort\": 69, \"frustration\": 66}\n")
+	block("block2", dual, "")
+	block("block10", dual, `survey: {"mental": 50, "physical": 50, "temporal": 50, "performance": 50, "effort": 50, "frustration": 50}`)
+
+	for _, test := range []struct {
+		weights   []float64
+		weighting string
+		// the weighted scores of block1 and block10
+		weighted [2]float64
+	}{
+		// (70*5 + 36*0 + 67*4 + 52*2 + 69*3 + 66*1) / 15 = 995 / 15
+		{[]float64{5, 0, 4, 2, 3, 1}, "weights", [2]float64{995.0 / 15, 50}},
+		// equal weights give the mean rating
+		{unweightedTLX, "unweighted", [2]float64{60, 50}},
+		{nil, "none", [2]float64{math.NaN(), math.NaN()}},
+	} {
+		scores, missing, err := subjectTLX(layout, 1, test.weights, test.weighting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(missing, []string{"block2"}) {
+			t.Errorf("missing %v, expected block2", missing)
+		}
+		// blocks are in the order of their numbers
+		if len(scores) != 2 || scores[0].Block != "block1" || scores[1].Block != "block10" {
+			t.Fatalf("scored %v, expected block1 and block10", scores)
+		}
+		if !reflect.DeepEqual(scores[0].Ratings, []float64{70, 36, 67, 52, 69, 66}) || scores[0].Raw != 360 || scores[1].Raw != 300 {
+			t.Errorf("block1 has ratings %v and raw scores %g and %g", scores[0].Ratings, scores[0].Raw, scores[1].Raw)
+		}
+		for index, score := range scores {
+			checkClose(t, score.Block+" "+test.weighting, score.Weighted, test.weighted[index], 1e-12)
+			if score.Weighting != test.weighting {
+				t.Errorf("%s is weighted by %s, expected %s", score.Block, score.Weighting, test.weighting)
+			}
+		}
+		if test.weighting == "none" {
+			var buffer bytes.Buffer
+			printTLXHeader(&buffer)
+			scores[0].print(&buffer)
+			want := "subject, block, practice, targets, speed, oprange, difficulty, mental, physical, temporal, performance, effort, frustration, raw, weighted, weighting\n" +
+				"1, block1, false, 1, 200, [1 12], 1, 70, 36, 67, 52, 69, 66, 360, NA, none\n"
+			if buffer.String() != want {
+				t.Errorf("printed %q, expected %q", buffer.String(), want)
+			}
+		}
+	}
+
+	// a survey without a subscale fails the subject
+	block("block3", dual, `survey: {"mental": 50, "physical": 50, "temporal": 50, "performance": 50, "effort": 50}`)
+	if _, _, err := subjectTLX(layout, 1, nil, "none"); err == nil || !strings.Contains(err.Error(), "has no rating for frustration") {
+		t.Errorf("a survey without frustration gave the error %v", err)
+	}
+}
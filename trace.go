@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TraceRecord is an action of a model module or server in trace.txt
+type TraceRecord struct {
+	// seconds on the model's clock, the same clock as the times of log.txt
+	Time float64
+	// the module or server, e.g. VISION or PROCEDURAL
+	Module string
+	// the rest of the line, e.g. PRODUCTION-FIRED ATTEND-TARGET
+	Action string
+	// the line of the file the record is on
+	Line int
+}
+
+// a trace line has the time, the module and the action separated by spaces, as in
+// "     1.250   MOTOR                  PREPARATION-COMPLETE"
+var traceLineRE = regexp.MustCompile(`^\s*(\d+(?:\.\d*)?)\s+(\S+)\s+(.*\S)\s*$`)
+
+// parse a model trace. lines that are not records, such as blank lines and the model's messages
+// about stopping under a "------" module, are skipped. times must not go backwards
+func parseTrace(r io.Reader) ([]TraceRecord, error) {
+	records := make([]TraceRecord, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		match := traceLineRE.FindStringSubmatch(scanner.Text())
+		if match == nil || strings.Trim(match[2], "-") == "" {
+			continue
+		}
+		time, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad time %q", number, match[1])
+		}
+		if len(records) > 0 && time < records[len(records)-1].Time {
+			return nil, fmt.Errorf("line %d: time %s is before the previous record", number, match[1])
+		}
+		records = append(records, TraceRecord{time, match[2], match[3], number})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no trace records found")
+	}
+	return records, nil
+}
+
+// read the model trace of a trial
+func readTrace(layout *Layout, subject int, block, trial string) ([]TraceRecord, error) {
+	path := filepath.Join(layout.TrialDir(subject, block, trial), "trace.txt")
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records, err := parseTrace(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return records, nil
+}
+
+// the records of a trace in the window [from, to]. the records must be in time order
+func traceWindow(records []TraceRecord, from, to float64) []TraceRecord {
+	start := sort.Search(len(records), func(i int) bool { return records[i].Time >= from })
+	end := sort.Search(len(records), func(i int) bool { return records[i].Time > to })
+	return records[start:end]
+}
+
+// a behavioural event of a trial at a time on the model's clock
+type trialEvent struct {
+	name string
+	time float64
+}
+
+var eventLineRE = regexp.MustCompile(`^(\w+), (\d{13})\b`)
+
+// get the events of a trial with the given names and their times in seconds since the clock's
+// zero. for model runs this is the clock of trace.txt, as data.txt is stamped from log.txt
+func trialEvents(data []byte, names map[string]bool) []trialEvent {
+	events := make([]trialEvent, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		match := eventLineRE.FindStringSubmatch(line)
+		if match == nil || !names[match[1]] {
+			continue
+		}
+		stamp, _ := strconv.ParseInt(match[2], 10, 64)
+		events = append(events, trialEvent{match[1], float64(stamp) / 1000})
+	}
+	return events
+}
+
+// EventActivity is the number of trace actions of each module around an event
+type EventActivity struct {
+	Event   string
+	Time    float64
+	Modules map[string]int
+}
+
+// count the actions of each module in a window around each event
+func alignTrace(events []trialEvent, records []TraceRecord, before, after float64) []EventActivity {
+	activity := make([]EventActivity, 0, len(events))
+	for _, event := range events {
+		modules := make(map[string]int)
+		for _, record := range traceWindow(records, event.time-before, event.time+after) {
+			modules[record.Module]++
+		}
+		activity = append(activity, EventActivity{event.name, event.time, modules})
+	}
+	return activity
+}
+
+func init() {
+	addCommand(&command{
+		name:  "align",
+		short: "show which model modules were busy around behavioural events",
+		long: `Align reads trace.txt of the selected model trials and, for each TargetHit and
+AdditionCorrect event (or the -events given) in data.txt, counts the trace actions of each
+module from -before seconds before the event to -after seconds after it. It prints one line
+per event and busy module with the event, its time on the model's clock, the module and the
+number of actions, or every action in the window with -v. Every subject is used unless -s
+names one, and trials without trace.txt, such as those of human subjects, are skipped.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			selection := addAnalysisSelectionFlags(flags)
+			var eventList string
+			var before, after float64
+			var verbose bool
+			flags.StringVar(&eventList, "events", "TargetHit,AdditionCorrect", "The events to align the trace with, separated by commas")
+			flags.Float64Var(&before, "before", 1, "The seconds of trace before each event to include")
+			flags.Float64Var(&after, "after", 0, "The seconds of trace after each event to include")
+			flags.BoolVar(&verbose, "v", false, "Print the actions in each window instead of counts")
+			return func(args []string) error {
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				names := make(map[string]bool)
+				for _, name := range strings.Split(eventList, ",") {
+					names[strings.TrimSpace(name)] = true
+				}
+				failed := false
+				for _, job := range selection.jobs(layout) {
+					records, err := readTrace(layout, job.subject, job.block, job.trial)
+					if os.IsNotExist(err) {
+						continue
+					} else if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						failed = true
+						continue
+					}
+					data, err := readTrialData(layout, job.subject, job.block, job.trial)
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						failed = true
+						continue
+					}
+					fmt.Printf("subject, %d, block %s, trial, %s\n", job.subject, job.block, job.trial)
+					events := trialEvents(data, names)
+					if verbose {
+						for _, event := range events {
+							fmt.Printf("%s, %f\n", event.name, event.time)
+							for _, record := range traceWindow(records, event.time-before, event.time+after) {
+								fmt.Printf("\t%f, %s, %s\n", record.Time, record.Module, record.Action)
+							}
+						}
+						continue
+					}
+					for _, activity := range alignTrace(events, records, before, after) {
+						modules := make([]string, 0, len(activity.Modules))
+						for module := range activity.Modules {
+							modules = append(modules, module)
+						}
+						sort.Strings(modules)
+						for _, module := range modules {
+							fmt.Printf("%s, %f, %s, %d\n", activity.Event, activity.Time, module, activity.Modules[module])
+						}
+					}
+				}
+				if failed {
+					return fmt.Errorf("some trials failed")
+				}
+				return nil
+			}
+		},
+	})
+}
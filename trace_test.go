@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testTrace = `     0.000   PROCEDURAL             CONFLICT-RESOLUTION
+     0.050   VISION                 ENCODING-COMPLETE
+     0.050   PROCEDURAL             PRODUCTION-FIRED ATTEND-TARGET
+
+     1.250   MOTOR                  PREPARATION-COMPLETE
+     1.300   ------                 Stopped because no events left to process
+     2       MOTOR                  FINISH-MOVEMENT  
+`
+
+func TestParseTrace(t *testing.T) {
+	records, err := parseTrace(strings.NewReader(testTrace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TraceRecord{
+		{0, "PROCEDURAL", "CONFLICT-RESOLUTION", 1},
+		{0.05, "VISION", "ENCODING-COMPLETE", 2},
+		{0.05, "PROCEDURAL", "PRODUCTION-FIRED ATTEND-TARGET", 3},
+		{1.25, "MOTOR", "PREPARATION-COMPLETE", 5},
+		{2, "MOTOR", "FINISH-MOVEMENT", 7},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("parsed %v, expected %v", records, want)
+	}
+
+	for _, test := range []struct {
+		source, err string
+	}{
+		{"1.5 VISION A\n1.25 MOTOR B\n", "line 2: time 1.25 is before the previous record"},
+		{"\n  ------ \n", "no trace records found"},
+		{"", "no trace records found"},
+	} {
+		if _, err := parseTrace(strings.NewReader(test.source)); err == nil || err.Error() != test.err {
+			t.Errorf("%q: got error %v, expected %s", test.source, err, test.err)
+		}
+	}
+}
+
+func TestAlignTrace(t *testing.T) {
+	records, err := parseTrace(strings.NewReader(testTrace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// windows include both ends
+	if window := traceWindow(records, 0.05, 1.25); len(window) != 3 || window[0].Line != 2 || window[2].Line != 5 {
+		t.Errorf("the window from 0.05 to 1.25 is %v", window)
+	}
+	if window := traceWindow(records, 2.5, 3); len(window) != 0 {
+		t.Errorf("the window after the trace is %v", window)
+	}
+
+	data := []byte("Start, 0000000000000, x\nAdditionCorrect, 0000000000100, 7\nShot, 0000000001250\n" +
+		"TargetHit, 0000000001300, 1\nTargetHit, 12345\n")
+	events := trialEvents(data, map[string]bool{"TargetHit": true, "AdditionCorrect": true})
+	if want := []trialEvent{{"AdditionCorrect", 0.1}, {"TargetHit", 1.3}}; !reflect.DeepEqual(events, want) {
+		t.Fatalf("the events are %v, expected %v", events, want)
+	}
+
+	for _, test := range []struct {
+		before, after float64
+		want          []map[string]int
+	}{
+		// from 0.1 - 1 to 0.1, and from 0.3 to 1.3
+		{1, 0, []map[string]int{{"PROCEDURAL": 2, "VISION": 1}, {"MOTOR": 1}}},
+		// from 0.1 - 0.06 to 0.1, and from 1.24 to 1.3
+		{0.06, 0, []map[string]int{{"PROCEDURAL": 1, "VISION": 1}, {"MOTOR": 1}}},
+		// from 0.1 to 1.1, and from 1.3 to 2.3
+		{0, 1, []map[string]int{{}, {"MOTOR": 1}}},
+	} {
+		activity := alignTrace(events, records, test.before, test.after)
+		for index, a := range activity {
+			if a.Event != events[index].name || a.Time != events[index].time || !reflect.DeepEqual(a.Modules, test.want[index]) {
+				t.Errorf("-before %g -after %g: %s at %g has %v, expected %v",
+					test.before, test.after, a.Event, a.Time, a.Modules, test.want[index])
+			}
+		}
+	}
+}
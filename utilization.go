@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the columns of the utilization tables of each kind of result file
+var utilizationColumns = map[string][]string{
+	"module":     {"clock", "vision", "audio", "production", "declarative", "imaginary", "motor", "speech"},
+	"subnetwork": {"clock", "perceptual", "cognitive", "motor"},
+}
+
+// the names the model gives the result files of each kind
+var utilizationResultFiles = map[string]string{
+	"module":     "Utilization_Each_Module.result",
+	"subnetwork": "Utilization_Each_SubNetwork.result",
+}
+
+// UtilizationElement is a <Snapshot> or <Item> element of a QN-ACTR result file
+type UtilizationElement struct {
+	// the element name, Snapshot or Item
+	Kind string
+	// the Value1, Value2, ... attributes in order
+	Values []string
+	// the line of the file the element starts on
+	Line int
+}
+
+// UtilizationResult is a parsed QN-ACTR utilization result file
+type UtilizationResult struct {
+	// the Snapshot and Item elements in document order
+	Elements []UtilizationElement
+}
+
+// get the Snapshot elements of a result
+func (r *UtilizationResult) Snapshots() []UtilizationElement {
+	return r.elementsOfKind("Snapshot")
+}
+
+// get the Item elements of a result
+func (r *UtilizationResult) Items() []UtilizationElement {
+	return r.elementsOfKind("Item")
+}
+
+func (r *UtilizationResult) elementsOfKind(kind string) []UtilizationElement {
+	elements := make([]UtilizationElement, 0)
+	for _, element := range r.Elements {
+		if element.Kind == kind {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+var valueAttrRE = regexp.MustCompile(`^Value(\d+)$`)
+
+// read the ValueN attributes of an element in order. returns nil if it has none
+func valueAttrs(element xml.StartElement) ([]string, error) {
+	values := make(map[int]string)
+	for _, attr := range element.Attr {
+		if match := valueAttrRE.FindStringSubmatch(attr.Name.Local); match != nil {
+			n, _ := strconv.Atoi(match[1])
+			values[n] = attr.Value
+		}
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	numbers := make([]int, 0, len(values))
+	for n := range values {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	ordered := make([]string, len(numbers))
+	for index, n := range numbers {
+		if n != index+1 {
+			return nil, fmt.Errorf("<%s> has Value%d without Value%d", element.Name.Local, n, index+1)
+		}
+		ordered[index] = strings.TrimSpace(values[n])
+	}
+	return ordered, nil
+}
+
+// parse a utilization result file. elements other than Snapshot and Item are allowed as
+// containers, but one carrying values means the format has changed and is an error
+func parseUtilizationResult(r io.Reader) (*UtilizationResult, error) {
+	decoder := xml.NewDecoder(r)
+	result := new(UtilizationResult)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		line, _ := decoder.InputPos()
+		values, err := valueAttrs(start)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		switch start.Name.Local {
+		case "Snapshot", "Item":
+			if values == nil {
+				return nil, fmt.Errorf("line %d: <%s> has no values", line, start.Name.Local)
+			}
+			result.Elements = append(result.Elements, UtilizationElement{start.Name.Local, values, line})
+		default:
+			if values != nil {
+				return nil, fmt.Errorf("line %d: unexpected element <%s> with values", line, start.Name.Local)
+			}
+		}
+	}
+	if len(result.Elements) == 0 {
+		return nil, fmt.Errorf("no Snapshot or Item elements found")
+	}
+	return result, nil
+}
+
+// UtilizationTable is the utilization of each module or subnetwork over the model's clock
+type UtilizationTable struct {
+	Columns []string
+	Rows    [][]float64
+}
+
+// convert a result to a table with the given columns. a first element that is not numeric is the
+// file's own header and is checked for width and dropped. every other element must have one
+// number per column, or the format has changed
+func (r *UtilizationResult) Table(columns []string) (*UtilizationTable, error) {
+	table := &UtilizationTable{Columns: columns, Rows: make([][]float64, 0, len(r.Elements))}
+	for index, element := range r.Elements {
+		if len(element.Values) != len(columns) {
+			return nil, fmt.Errorf("line %d: <%s> has %d values, expected %d (%s)",
+				element.Line, element.Kind, len(element.Values), len(columns), strings.Join(columns, ", "))
+		}
+		row := make([]float64, len(columns))
+		numeric := true
+		for column, value := range element.Values {
+			var err error
+			if row[column], err = strconv.ParseFloat(value, 64); err != nil {
+				numeric = false
+			}
+		}
+		if !numeric {
+			if index == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: <%s> has values that are not numbers: %s",
+				element.Line, element.Kind, strings.Join(element.Values, ", "))
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, nil
+}
+
+// write a table as CSV with a header
+func (t *UtilizationTable) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write(t.Columns)
+	for _, row := range t.Rows {
+		record := make([]string, len(row))
+		for index, value := range row {
+			record[index] = strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// read a table written by WriteCSV, i.e. module.txt or subnetwork.txt
+func readUtilizationTable(path string) (*UtilizationTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s: no header", path)
+	}
+	table := &UtilizationTable{Columns: records[0], Rows: make([][]float64, 0, len(records)-1)}
+	for line, record := range records[1:] {
+		row := make([]float64, len(record))
+		for index, value := range record {
+			if row[index], err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("%s: line %d: %q is not a number", path, line+2, value)
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, nil
+}
+
+// get the kind of a result file from its name, as the model names them
+func utilizationKind(path string) (string, bool) {
+	for kind, name := range utilizationResultFiles {
+		if filepath.Base(path) == name {
+			return kind, true
+		}
+	}
+	return "", false
+}
+
+// convert a result file of a kind to a CSV table
+func convertUtilization(src string, kind string, w io.Writer) error {
+	columns, ok := utilizationColumns[kind]
+	if !ok {
+		return fmt.Errorf("unknown utilization kind %q", kind)
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	result, err := parseUtilizationResult(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", src, err)
+	}
+	table, err := result.Table(columns)
+	if err != nil {
+		return fmt.Errorf("%s: %v", src, err)
+	}
+	return table.WriteCSV(w)
+}
+
+func init() {
+	addCommand(&command{
+		name:  "utilization",
+		args:  "<result file>",
+		short: "convert a QN-ACTR utilization result file to CSV",
+		long: `Utilization reads a Utilization_Each_Module.result or Utilization_Each_SubNetwork.result
+file and writes its Snapshot and Item values as CSV with a header, as module.txt and
+subnetwork.txt are stored. It fails if the file does not have the expected number of values
+per element, which means the model's output format has changed.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			var kind, out string
+			flags.StringVar(&kind, "kind", "", "module or subnetwork (default from the file name)")
+			flags.StringVar(&out, "out", "-", "The file to write, or - for stdout")
+			return func(args []string) error {
+				if len(args) != 1 {
+					return fmt.Errorf("expected one result file")
+				}
+				if kind == "" {
+					var ok bool
+					if kind, ok = utilizationKind(args[0]); !ok {
+						return fmt.Errorf("cannot tell the kind of %s, use -kind", args[0])
+					}
+				}
+				var w io.WriteCloser = nopWriteCloser{os.Stdout}
+				if out != "-" {
+					file, err := os.Create(out)
+					if err != nil {
+						return err
+					}
+					w = file
+				}
+				err := convertUtilization(args[0], kind, w)
+				if closeErr := w.Close(); err == nil {
+					err = closeErr
+				}
+				return err
+			}
+		},
+	})
+}
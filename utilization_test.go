@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseUtilizationResult(t *testing.T) {
+	source := `<?xml version="1.0"?>
+<Results>
+  <Snapshot Value1="Clock" Value2="Perceptual" Value3="Cognitive" Value4="Motor"/>
+  <Items>
+    <Item Value2=" 0.5" Value1="1" Value3="0.25" Value4="0"/>
+    <Snapshot Value1="2" Value2="0.75" Value3="0.125" Value4="1e-2"/>
+  </Items>
+</Results>
+`
+	result, err := parseUtilizationResult(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Snapshots()) != 2 || len(result.Items()) != 1 {
+		t.Errorf("got %d snapshots and %d items, expected 2 and 1", len(result.Snapshots()), len(result.Items()))
+	}
+	// the values are ordered by their number, not by the order of the attributes, and trimmed
+	if item := result.Items()[0]; !reflect.DeepEqual(item.Values, []string{"1", "0.5", "0.25", "0"}) || item.Line != 5 {
+		t.Errorf("the item has values %q on line %d", item.Values, item.Line)
+	}
+
+	table, err := result.Table(utilizationColumns["subnetwork"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the file's own header is dropped
+	if want := [][]float64{{1, 0.5, 0.25, 0}, {2, 0.75, 0.125, 0.01}}; !reflect.DeepEqual(table.Rows, want) {
+		t.Errorf("the rows are %v, expected %v", table.Rows, want)
+	}
+	var buffer bytes.Buffer
+	if err := table.WriteCSV(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	csv := "clock,perceptual,cognitive,motor\n1,0.5,0.25,0\n2,0.75,0.125,0.01\n"
+	if buffer.String() != csv {
+		t.Errorf("wrote %q, expected %q", buffer.String(), csv)
+	}
+
+	dir, err := ioutil.TempDir("", "utilization")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "subnetwork.txt")
+	if err := ioutil.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if read, err := readUtilizationTable(path); err != nil || !reflect.DeepEqual(read, table) {
+		t.Errorf("read back %v and %v, expected %v", read, err, table)
+	}
+}
+
+func TestUtilizationErrors(t *testing.T) {
+	columns := utilizationColumns["subnetwork"]
+	for _, test := range []struct {
+		source, err string
+	}{
+		{`<R><Item Value1="1" Value3="2"/></R>`, "line 1: <Item> has Value3 without Value2"},
+		{`<R><Item/></R>`, "<Item> has no values"},
+		{`<R><Row Value1="1"/></R>`, "unexpected element <Row> with values"},
+		{`<R></R>`, "no Snapshot or Item elements found"},
+		{`<R><Item Value1="1"`, "unexpected EOF"},
+		{`<R><Item Value1="1" Value2="2" Value3="3"/></R>`, "<Item> has 3 values, expected 4"},
+		{"<R>\n<Item Value1=\"1\" Value2=\"2\" Value3=\"3\" Value4=\"4\"/>\n<Item Value1=\"a\" Value2=\"2\" Value3=\"3\" Value4=\"4\"/></R>",
+			"line 3: <Item> has values that are not numbers: a, 2, 3, 4"},
+	} {
+		result, err := parseUtilizationResult(strings.NewReader(test.source))
+		if err == nil {
+			_, err = result.Table(columns)
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, expected %s", test.source, err, test.err)
+		}
+	}
+
+	for name, kind := range map[string]string{
+		"/run/Utilization_Each_Module.result": "module", "Utilization_Each_SubNetwork.result": "subnetwork", "other.result": "",
+	} {
+		if got, _ := utilizationKind(name); got != kind {
+			t.Errorf("%s is of kind %q, expected %q", name, got, kind)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// BlockWorkload is the mean utilization of each module or subnetwork of a model run over a span of the model's clock
+type BlockWorkload struct {
+	Subject int
+	Block   string
+	Levels  *IVLevels
+	// module or subnetwork
+	Kind string
+	// the index of the time window, or -1 for the whole block
+	Window     int
+	Start, End float64
+	// the units in column order and the mean utilization of each
+	Units       []string
+	Utilization []float64
+}
+
+// read the utilization table of a kind of a block, with the columns named as analysis.R names them
+func readBlockUtilization(layout *Layout, subject int, block, kind string) (*UtilizationTable, error) {
+	path := filepath.Join(layout.BlockDir(subject, block), kind+".txt")
+	table, err := readUtilizationTable(path)
+	if err != nil {
+		return nil, err
+	}
+	columns := utilizationColumns[kind]
+	if len(table.Columns) != len(columns) {
+		return nil, fmt.Errorf("%s: %d columns, expected %d", path, len(table.Columns), len(columns))
+	}
+	table.Columns = columns
+	return table, nil
+}
+
+// average the utilization snapshots of a table whose clock is in [start, end). the clock is the first column
+func meanUtilization(table *UtilizationTable, start, end float64) ([]float64, int) {
+	sums := make([]float64, len(table.Columns)-1)
+	n := 0
+	for _, row := range table.Rows {
+		if row[0] < start || row[0] >= end {
+			continue
+		}
+		for index, value := range row[1:] {
+			sums[index] += value
+		}
+		n++
+	}
+	for index := range sums {
+		sums[index] /= float64(n)
+	}
+	return sums, n
+}
+
+// compute the workload of a block over the whole run and, if window is positive, over each
+// window of that many seconds from the start of the model's clock
+func blockWorkload(subject int, block string, levels *IVLevels, kind string, table *UtilizationTable, window float64) []BlockWorkload {
+	workloads := make([]BlockWorkload, 0)
+	if len(table.Rows) == 0 {
+		return workloads
+	}
+	first, last := table.Rows[0][0], table.Rows[len(table.Rows)-1][0]
+	units := table.Columns[1:]
+	means, _ := meanUtilization(table, math.Inf(-1), math.Inf(1))
+	workloads = append(workloads, BlockWorkload{subject, block, levels, kind, -1, first, last, units, means})
+	if window <= 0 {
+		return workloads
+	}
+	for index := 0; float64(index)*window <= last; index++ {
+		start := float64(index) * window
+		if means, n := meanUtilization(table, start, start+window); n > 0 {
+			workloads = append(workloads, BlockWorkload{subject, block, levels, kind, index, start, start + window, units, means})
+		}
+	}
+	return workloads
+}
+
+// compute the workloads of the blocks of a subject that have model utilization results
+func subjectWorkload(layout *Layout, subject int, options aggregateOptions, window float64) ([]BlockWorkload, error) {
+	workloads := make([]BlockWorkload, 0)
+	blocks := layout.Blocks(subject)
+	if options.blockName != "" {
+		blocks = []string{options.blockName}
+	}
+	for _, block := range blocks {
+		levels := getBlockIVLevels(layout, subject, block)
+		if levels == nil {
+			continue
+		}
+		for _, kind := range []string{"module", "subnetwork"} {
+			if _, err := os.Stat(filepath.Join(layout.BlockDir(subject, block), kind+".txt")); os.IsNotExist(err) {
+				continue
+			}
+			table, err := readBlockUtilization(layout, subject, block, kind)
+			if err != nil {
+				return nil, err
+			}
+			workloads = append(workloads, blockWorkload(subject, block, levels, kind, table, window)...)
+		}
+	}
+	return workloads, nil
+}
+
+// print the header of workload.txt
+func printWorkloadHeader(w io.Writer) {
+	fmt.Fprintln(w, "subject, block, practice, targets, speed, oprange, difficulty, kind, unit, window, start, end, utilization")
+}
+
+// print a workload as rows of workload.txt, one per unit
+func (b BlockWorkload) print(w io.Writer) {
+	window := "all"
+	if b.Window >= 0 {
+		window = fmt.Sprint(b.Window)
+	}
+	for index, unit := range b.Units {
+		fmt.Fprintf(w, "%d, %s, %t, %d, %d, %v, %d, %s, %s, %s, %f, %f, %f\n",
+			b.Subject, b.Block, b.Levels.Practice,
+			b.Levels.TargetNumber, b.Levels.TargetSpeed, b.Levels.AdditionDifficulty, b.Levels.TargetDifficulty,
+			b.Kind, unit, window, b.Start, b.End, b.Utilization[index])
+	}
+}
+
+// write workload.txt for each subject with model results, or one table of all the subjects if out is a file or stdout.
+// returns false if any subject failed
+func writeWorkload(layout *Layout, subjects []int, out string, options aggregateOptions, window float64) bool {
+	ok := true
+	var combined io.WriteCloser
+	if out == "-" || out != "" && !isDirSetting(out) {
+		var err error
+		if combined, err = createOutput(out, layout, 0, "workload.txt"); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing workload: %v\n", err)
+			return false
+		}
+		printWorkloadHeader(combined)
+	}
+	for _, subject := range subjects {
+		workloads, err := subjectWorkload(layout, subject, options, window)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "not writing workload of subject %d: %v\n", subject, err)
+			ok = false
+			continue
+		}
+		if len(workloads) == 0 {
+			fmt.Fprintf(os.Stderr, "subject %d has no model utilization results\n", subject)
+			continue
+		}
+		if combined != nil {
+			for _, workload := range workloads {
+				workload.print(combined)
+			}
+			continue
+		}
+		file, err := createOutput(out, layout, subject, "workload.txt")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error writing workload of subject %d: %v\n", subject, err)
+			ok = false
+			continue
+		}
+		printWorkloadHeader(file)
+		for _, workload := range workloads {
+			workload.print(file)
+		}
+		if err := file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing workload of subject %d: %v\n", subject, err)
+			ok = false
+		}
+	}
+	if combined != nil {
+		if err := combined.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing workload: %v\n", err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func init() {
+	addCommand(&command{
+		name:  "workload",
+		short: "write the mean model utilization of each block to workload.txt",
+		long: `Workload reads module.txt and subnetwork.txt of each block of the selected subjects and
+writes workload.txt next to r1.txt, with one row per block, module or subnetwork and time window
+giving the subject, the block conditions and the mean of the utilization snapshots. The window
+"all" covers the whole run, as analysis.R's getWorkloadMean did; with -window the run is also
+split into windows of that many seconds of the model's clock. Blocks without model results are
+skipped. If -out is a file or - the rows of all the subjects are written there as one table.`,
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			dataset := addDatasetFlags(flags)
+			selection := addSelectionFlags(flags)
+			var out string
+			var window float64
+			flags.StringVar(&out, "out", "", "Where to write results: a file, a directory, or - for stdout (default workload.txt in the subject directory)")
+			flags.Float64Var(&window, "window", 0, "The length in seconds of the time windows, or 0 for whole blocks only")
+			return func(args []string) error {
+				layout, err := dataset.layout()
+				if err != nil {
+					return err
+				}
+				if !writeWorkload(layout, selection.subjects(layout), out, selection.options, window) {
+					return fmt.Errorf("some subjects failed")
+				}
+				return nil
+			}
+		},
+	})
+}
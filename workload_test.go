@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func workloadTable() *UtilizationTable {
+	return &UtilizationTable{Columns: []string{"clock", "perceptual", "cognitive"}, Rows: [][]float64{
+		{0, 0.5, 0.25}, {1, 0.25, 0.75}, {2, 0, 1}, {3, 1, 0}, {4.5, 0.75, 0.5},
+	}}
+}
+
+func TestMeanUtilization(t *testing.T) {
+	table := workloadTable()
+	for _, test := range []struct {
+		start, end float64
+		want       []float64
+		n          int
+	}{
+		{math.Inf(-1), math.Inf(1), []float64{0.5, 0.5}, 5},
+		// windows include their start but not their end
+		{0, 2, []float64{0.375, 0.5}, 2},
+		{1, 3, []float64{0.125, 0.875}, 2},
+		{4.5, 5, []float64{0.75, 0.5}, 1},
+	} {
+		means, n := meanUtilization(table, test.start, test.end)
+		if n != test.n || !reflect.DeepEqual(means, test.want) {
+			t.Errorf("from %g to %g: got %v of %d snapshots, expected %v of %d", test.start, test.end, means, n, test.want, test.n)
+		}
+	}
+	if means, n := meanUtilization(table, 5, 6); n != 0 || !math.IsNaN(means[0]) {
+		t.Errorf("a window without snapshots has %v of %d", means, n)
+	}
+}
+
+func TestBlockWorkload(t *testing.T) {
+	levels := &IVLevels{TargetNumber: 1, TargetSpeed: 200, AdditionDifficulty: []int{1, 12}, TargetDifficulty: 1}
+	units := []string{"perceptual", "cognitive"}
+	want := []BlockWorkload{
+		{3, "b", levels, "subnetwork", -1, 0, 4.5, units, []float64{0.5, 0.5}},
+		{3, "b", levels, "subnetwork", 0, 0, 2, units, []float64{0.375, 0.5}},
+		{3, "b", levels, "subnetwork", 1, 2, 4, units, []float64{0.5, 0.5}},
+		{3, "b", levels, "subnetwork", 2, 4, 6, units, []float64{0.75, 0.5}},
+	}
+	workloads := blockWorkload(3, "b", levels, "subnetwork", workloadTable(), 2)
+	if !reflect.DeepEqual(workloads, want) {
+		t.Errorf("got %v, expected %v", workloads, want)
+	}
+	// windows without snapshots are left out, so of the 10 half seconds only those at 0, 1, 2, 3 and 4.5 are kept
+	workloads = blockWorkload(3, "b", levels, "subnetwork", workloadTable(), 0.5)
+	kept := make([]int, 0)
+	for _, w := range workloads[1:] {
+		kept = append(kept, w.Window)
+	}
+	if !reflect.DeepEqual(kept, []int{0, 2, 4, 6, 9}) {
+		t.Errorf("got the half second windows %v, expected 0, 2, 4, 6 and 9", kept)
+	}
+	if workloads := blockWorkload(3, "b", levels, "subnetwork", workloadTable(), 0); !reflect.DeepEqual(workloads, want[:1]) {
+		t.Errorf("without windows got %v, expected %v", workloads, want[:1])
+	}
+	if workloads := blockWorkload(3, "b", levels, "subnetwork", &UtilizationTable{Columns: workloadTable().Columns}, 2); len(workloads) != 0 {
+		t.Errorf("a table without snapshots has workloads %v", workloads)
+	}
+
+	var buffer bytes.Buffer
+	printWorkloadHeader(&buffer)
+	want[0].print(&buffer)
+	want[1].print(&buffer)
+	text := "subject, block, practice, targets, speed, oprange, difficulty, kind, unit, window, start, end, utilization\n" +
+		"3, b, false, 1, 200, [1 12], 1, subnetwork, perceptual, all, 0.000000, 4.500000, 0.500000\n" +
+		"3, b, false, 1, 200, [1 12], 1, subnetwork, cognitive, all, 0.000000, 4.500000, 0.500000\n" +
+		"3, b, false, 1, 200, [1 12], 1, subnetwork, perceptual, 0, 0.000000, 2.000000, 0.375000\n" +
+		"3, b, false, 1, 200, [1 12], 1, subnetwork, cognitive, 0, 0.000000, 2.000000, 0.500000\n"
+	if buffer.String() != text {
+		t.Errorf("printed\n%s\nexpected\n%s", buffer.String(), text)
+	}
+}
+
+func TestReadBlockUtilization(t *testing.T) {
+	layout, _, _ := batchFixture(t)
+	writeTestFile(t, filepath.Join(layout.BlockDir(1, "b"), "subnetwork.txt"), "Clock,Perceptual,Cognitive,Motor\n0,0.5,0.25,0\n")
+	table, err := readBlockUtilization(layout, 1, "b", "subnetwork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the columns are named as analysis.R names them, whatever the file's header
+	if !reflect.DeepEqual(table.Columns, utilizationColumns["subnetwork"]) || !reflect.DeepEqual(table.Rows, [][]float64{{0, 0.5, 0.25, 0}}) {
+		t.Errorf("read %v", table)
+	}
+	writeTestFile(t, filepath.Join(layout.BlockDir(1, "b"), "module.txt"), "clock,vision\n0,1\n")
+	if _, err := readBlockUtilization(layout, 1, "b", "module"); err == nil {
+		t.Errorf("a module table of 2 columns was read")
+	}
+}